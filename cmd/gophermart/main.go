@@ -1,14 +1,17 @@
 package main
 
 import (
-	"database/sql"
 	"github.com/kuznet1/gophermart/internal/accrual"
 	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/events"
 	"github.com/kuznet1/gophermart/internal/handler"
 	"github.com/kuznet1/gophermart/internal/logger"
 	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/notify"
+	"github.com/kuznet1/gophermart/internal/password"
 	"github.com/kuznet1/gophermart/internal/repository"
 	"github.com/kuznet1/gophermart/internal/service"
+	"github.com/kuznet1/gophermart/internal/webhook"
 	"go.uber.org/zap"
 	"net/http"
 )
@@ -19,22 +22,51 @@ func main() {
 		logger.Log.Fatal("unable to parse config", zap.Error(err))
 	}
 
-	db, err := repository.InitDBConnection(cfg)
-	if err != nil {
-		logger.Log.Fatal("failed to init sql connection", zap.Error(err))
+	var repo repository.Storage
+	if cfg.Storage == "memory" {
+		logger.Log.Warn("using in-memory storage, all data will be lost on exit")
+		repo = repository.NewMemRepo()
+	} else {
+		db, closeDB, err := repository.InitDBConnection(cfg)
+		if err != nil {
+			logger.Log.Fatal("failed to init sql connection", zap.Error(err))
+		}
+		defer closeDB()
+		repo = repository.NewRepo(db)
 	}
 
-	startService(db, cfg)
+	startService(repo, cfg)
 }
 
-func startService(db *sql.DB, cfg config.Config) {
-	repo := repository.NewRepo(db)
-	acc := accrual.NewAccrual(cfg.AccrualSystemAddress, repo)
+func startService(repo repository.Storage, cfg config.Config) {
+	broker := events.NewBroker()
+	webhooks := webhook.NewDispatcher(cfg, repo)
+	webhooks.Start()
+	defer webhooks.Stop()
+	acc := accrual.NewAccrual(cfg.AccrualSystemAddress, repo, broker, webhooks)
 	acc.Start()
 	defer acc.Stop()
-	auth := middleware.NewAuth(cfg)
-	svc := service.NewService(repo, auth, acc)
-	h := handler.NewHandler(svc, auth)
+	validator, err := service.NewCredentialsValidator(cfg)
+	if err != nil {
+		logger.Log.Fatal("failed to init credentials validator", zap.Error(err))
+	}
+	passwords, err := password.NewManager(cfg, password.NewPool(cfg.HashWorkers, cfg.HashQueueSize))
+	if err != nil {
+		logger.Log.Fatal("failed to init password hashing", zap.Error(err))
+	}
+	resets, err := notify.NewSender(cfg)
+	if err != nil {
+		logger.Log.Fatal("failed to init password reset delivery", zap.Error(err))
+	}
+	auth, err := middleware.NewAuth(cfg, repo)
+	if err != nil {
+		logger.Log.Fatal("failed to init authentication", zap.Error(err))
+	}
+	svc := service.NewService(cfg, repo, auth, acc, broker, webhooks, validator, passwords, resets)
+	if err = svc.SyncAdmins(); err != nil {
+		logger.Log.Fatal("failed to sync admins", zap.Error(err))
+	}
+	h := handler.NewHandler(cfg, svc, auth)
 
 	logger.Log.Info("Gophermart service is running at " + cfg.RunAddress)
 	logger.Log.Fatal(http.ListenAndServe(cfg.RunAddress, h.Router()).Error())
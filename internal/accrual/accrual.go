@@ -3,8 +3,10 @@ package accrual
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/kuznet1/gophermart/internal/events"
 	"github.com/kuznet1/gophermart/internal/model"
 	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/kuznet1/gophermart/internal/webhook"
 	"io"
 	"net/http"
 )
@@ -16,14 +18,18 @@ type Accrualer interface {
 type Accrual struct {
 	signal               chan struct{}
 	accrualSystemAddress string
-	repo                 *repository.Repo
+	repo                 repository.AccrualQueue
+	events               *events.Broker
+	webhooks             *webhook.Dispatcher
 }
 
-func NewAccrual(accrualSystemAddress string, repo *repository.Repo) *Accrual {
+func NewAccrual(accrualSystemAddress string, repo repository.AccrualQueue, events *events.Broker, webhooks *webhook.Dispatcher) *Accrual {
 	return &Accrual{
 		signal:               make(chan struct{}, 1),
 		accrualSystemAddress: accrualSystemAddress,
 		repo:                 repo,
+		events:               events,
+		webhooks:             webhooks,
 	}
 }
 
@@ -58,8 +64,8 @@ func (a *Accrual) Signal() {
 	}
 }
 
-func (a *Accrual) updateOrderData(order int) error {
-	url := fmt.Sprintf("%s/api/orders/%d", a.accrualSystemAddress, order)
+func (a *Accrual) updateOrderData(order string) error {
+	url := fmt.Sprintf("%s/api/orders/%s", a.accrualSystemAddress, order)
 	response, err := http.Get(url)
 	if err != nil {
 		return err
@@ -78,10 +84,16 @@ func (a *Accrual) updateOrderData(order int) error {
 			return err
 		}
 
-		err = a.repo.UpdateAccrual(accrual)
+		event, err := a.repo.UpdateAccrual(accrual)
 		if err != nil {
 			return err
 		}
+		if event != nil {
+			a.events.Publish(*event)
+			if event.Status == "PROCESSED" {
+				a.webhooks.Notify(event.UserID, webhook.EventOrderProcessed, event)
+			}
+		}
 	case http.StatusTooManyRequests:
 		a.Signal()
 	case http.StatusInternalServerError:
@@ -2,16 +2,74 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"github.com/caarlos0/env/v6"
 	"github.com/kuznet1/gophermart/internal/logger"
+	"golang.org/x/crypto/bcrypt"
+	"math"
+	"runtime"
+	"strings"
+	"time"
 )
 
+const minSecretKeyLength = 32
+
 type Config struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	MigrationsPath       string `env:"MIGRATIONS_PATH"`
-	SecretKey            string `env:"SECRET_KEY"`
+	RunAddress            string        `env:"RUN_ADDRESS"`
+	AccrualSystemAddress  string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	DatabaseURI           string        `env:"DATABASE_URI"`
+	Storage               string        `env:"STORAGE"`
+	DBMaxConns            int           `env:"DB_MAX_CONNS"`
+	DBMinConns            int           `env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime     time.Duration `env:"DB_MAX_CONN_LIFETIME"`
+	DBMaxConnIdleTime     time.Duration `env:"DB_MAX_CONN_IDLE_TIME"`
+	DBHealthCheckPeriod   time.Duration `env:"DB_HEALTH_CHECK_PERIOD"`
+	DBStatementCacheMode  string        `env:"DB_STATEMENT_CACHE_MODE"`
+	DBStatementCacheSize  int           `env:"DB_STATEMENT_CACHE_SIZE"`
+	DBConnectTimeout      time.Duration `env:"DB_CONNECT_TIMEOUT"`
+	DBConnectAttempts     int           `env:"DB_CONNECT_ATTEMPTS"`
+	DBConnectBackoff      time.Duration `env:"DB_CONNECT_BACKOFF"`
+	MigrationsPath        string        `env:"MIGRATIONS_PATH"`
+	SecretKey             string        `env:"SECRET_KEY"`
+	SecretKeyID           string        `env:"SECRET_KEY_ID"`
+	VerifyKeys            string        `env:"VERIFY_KEYS"`
+	SigningKeyFile        string        `env:"SIGNING_KEY_FILE"`
+	VerifyKeyFiles        string        `env:"VERIFY_KEY_FILES"`
+	DevMode               bool          `env:"DEV_MODE"`
+	AccessTokenTTL        time.Duration `env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL       time.Duration `env:"REFRESH_TOKEN_TTL"`
+	CookieDomain          string        `env:"COOKIE_DOMAIN"`
+	CookiePath            string        `env:"COOKIE_PATH"`
+	CookieSecure          bool          `env:"COOKIE_SECURE"`
+	CookieHTTPOnly        bool          `env:"COOKIE_HTTP_ONLY"`
+	CookieSameSite        string        `env:"COOKIE_SAME_SITE"`
+	LoginPattern          string        `env:"LOGIN_PATTERN"`
+	PasswordMinLength     int           `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMaxLength     int           `env:"PASSWORD_MAX_LENGTH"`
+	PasswordBlocklistPath string        `env:"PASSWORD_BLOCKLIST_PATH"`
+	TrustProxyHeaders     bool          `env:"TRUST_PROXY_HEADERS"`
+	CSRFTrustedOrigins    string        `env:"CSRF_TRUSTED_ORIGINS"`
+	CSRFDoubleSubmit      bool          `env:"CSRF_DOUBLE_SUBMIT"`
+	LoginMaxFailures      int           `env:"LOGIN_MAX_FAILURES"`
+	LoginIPMaxFailures    int           `env:"LOGIN_IP_MAX_FAILURES"`
+	LoginThrottleDelay    time.Duration `env:"LOGIN_THROTTLE_DELAY"`
+	LoginLockoutDuration  time.Duration `env:"LOGIN_LOCKOUT_DURATION"`
+	AdminLogins           string        `env:"ADMIN_LOGINS"`
+	TOTPIssuer            string        `env:"TOTP_ISSUER"`
+	TOTPWithdrawThreshold float64       `env:"TOTP_WITHDRAW_THRESHOLD"`
+	AdjustmentThreshold   float64       `env:"ADJUSTMENT_APPROVAL_THRESHOLD"`
+	PasswordHashAlgorithm string        `env:"PASSWORD_HASH_ALGORITHM"`
+	BcryptCost            int           `env:"BCRYPT_COST"`
+	Argon2Memory          uint          `env:"ARGON2_MEMORY"`
+	Argon2Iterations      uint          `env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism     uint          `env:"ARGON2_PARALLELISM"`
+	HashWorkers           int           `env:"HASH_WORKERS"`
+	HashQueueSize         int           `env:"HASH_QUEUE_SIZE"`
+	ResetDelivery         string        `env:"RESET_DELIVERY"`
+	ResetDeliveryFile     string        `env:"RESET_DELIVERY_FILE"`
+	PasswordResetTTL      time.Duration `env:"PASSWORD_RESET_TTL"`
+	AccountRetention      string        `env:"ACCOUNT_RETENTION"`
+	WebhookAllowedHosts   string        `env:"WEBHOOK_ALLOWED_HOSTS"`
 }
 
 func NewConfig() (Config, error) {
@@ -19,16 +77,115 @@ func NewConfig() (Config, error) {
 	flag.StringVar(&cfg.RunAddress, "a", ":8086", "Server address")
 	flag.StringVar(&cfg.AccrualSystemAddress, "r", "localhost:8080", "Accrual service address")
 	flag.StringVar(&cfg.DatabaseURI, "d", "postgres://postgres@localhost:5432/gophermart", "Database URI")
+	flag.StringVar(&cfg.Storage, "storage", "postgres", "Storage backend: postgres, or memory to run without a database, losing all data on exit")
+	flag.IntVar(&cfg.DBMaxConns, "db-max-conns", 10, "Maximum number of open database connections")
+	flag.IntVar(&cfg.DBMinConns, "db-min-conns", 0, "Number of database connections kept open even when idle")
+	flag.DurationVar(&cfg.DBMaxConnLifetime, "db-max-conn-lifetime", time.Hour, "Database connections older than this are closed and replaced")
+	flag.DurationVar(&cfg.DBMaxConnIdleTime, "db-max-conn-idle-time", 30*time.Minute, "Database connections idle longer than this are closed")
+	flag.DurationVar(&cfg.DBHealthCheckPeriod, "db-health-check-period", time.Minute, "How often idle database connections are checked")
+	flag.StringVar(&cfg.DBStatementCacheMode, "db-statement-cache-mode", "cache_statement", "How queries are executed: cache_statement, cache_describe, describe_exec, exec or simple_protocol, the last one for poolers like PgBouncer in transaction mode")
+	flag.IntVar(&cfg.DBStatementCacheSize, "db-statement-cache-size", 512, "Prepared statements or descriptions cached per database connection")
+	flag.DurationVar(&cfg.DBConnectTimeout, "db-connect-timeout", 5*time.Second, "Timeout of establishing a database connection")
+	flag.IntVar(&cfg.DBConnectAttempts, "db-connect-attempts", 5, "Attempts to reach the database at startup before giving up")
+	flag.DurationVar(&cfg.DBConnectBackoff, "db-connect-backoff", time.Second, "Delay before the second attempt to reach the database, doubled on every next one")
 	flag.StringVar(&cfg.MigrationsPath, "m", "file://migrations", "Migrations path")
-	flag.StringVar(&cfg.SecretKey, "k", "", "secret key for cookie signing")
+	flag.StringVar(&cfg.SecretKey, "k", "", "secret key for token signing")
+	flag.StringVar(&cfg.SecretKeyID, "key-id", "", "ID of the signing key, derived from the key if empty")
+	flag.StringVar(&cfg.VerifyKeys, "verify-keys", "", "Comma separated kid:secret pairs of old keys still accepted for verification")
+	flag.StringVar(&cfg.SigningKeyFile, "signing-key", "", "PEM file with an RSA or Ed25519 private key to sign tokens with instead of the secret key")
+	flag.StringVar(&cfg.VerifyKeyFiles, "verify-key-files", "", "Comma separated PEM files with old public keys still accepted for verification")
+	flag.BoolVar(&cfg.DevMode, "dev", false, "Development mode, allows a weak or empty secret key")
+	flag.DurationVar(&cfg.AccessTokenTTL, "access-ttl", 15*time.Minute, "Access token lifetime")
+	flag.DurationVar(&cfg.RefreshTokenTTL, "refresh-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.StringVar(&cfg.CookieDomain, "cookie-domain", "", "Auth cookie domain")
+	flag.StringVar(&cfg.CookiePath, "cookie-path", "/", "Auth cookie path")
+	flag.BoolVar(&cfg.CookieSecure, "cookie-secure", true, "Send auth cookies over HTTPS only")
+	flag.BoolVar(&cfg.CookieHTTPOnly, "cookie-http-only", true, "Hide auth cookies from JavaScript")
+	flag.StringVar(&cfg.CookieSameSite, "cookie-same-site", "strict", "Auth cookie SameSite mode: strict, lax or none")
+	flag.StringVar(&cfg.LoginPattern, "login-pattern", `^[[:graph:]]{3,64}$`, "Regular expression logins must match")
+	flag.IntVar(&cfg.PasswordMinLength, "password-min-length", 8, "Minimum password length in characters")
+	flag.IntVar(&cfg.PasswordMaxLength, "password-max-length", 72, "Maximum password length in bytes")
+	flag.StringVar(&cfg.PasswordBlocklistPath, "password-blocklist", "", "File with common passwords to reject, one per line")
+	flag.StringVar(&cfg.CSRFTrustedOrigins, "csrf-trusted-origins", "", "Comma separated origins allowed to make cookie-authenticated requests, e.g. https://shop.example.com")
+	flag.BoolVar(&cfg.CSRFDoubleSubmit, "csrf-double-submit", false, "Require the X-CSRF-Token header to match the csrf_token cookie on cookie-authenticated requests")
+	flag.BoolVar(&cfg.TrustProxyHeaders, "trust-proxy-headers", false, "Take client IP from X-Forwarded-For/X-Real-IP")
+	flag.IntVar(&cfg.LoginMaxFailures, "login-max-failures", 10, "Failed logins per account before a lockout, 0 disables throttling")
+	flag.IntVar(&cfg.LoginIPMaxFailures, "login-ip-max-failures", 100, "Failed logins per IP before a lockout, 0 disables throttling")
+	flag.DurationVar(&cfg.LoginThrottleDelay, "login-throttle-delay", time.Second, "Initial delay between failed logins, doubled on every failure")
+	flag.DurationVar(&cfg.LoginLockoutDuration, "login-lockout-duration", 15*time.Minute, "Lockout duration and failure counting window")
+	flag.StringVar(&cfg.AdminLogins, "admin-logins", "", "Comma separated logins of the admins, synced at startup if set: other admins are demoted")
+	flag.StringVar(&cfg.TOTPIssuer, "totp-issuer", "Gophermart", "Issuer shown in authenticator apps")
+	flag.Float64Var(&cfg.TOTPWithdrawThreshold, "totp-withdraw-threshold", 1000, "Withdrawals above this sum need a two-factor code if the user has it enabled")
+	flag.Float64Var(&cfg.AdjustmentThreshold, "adjustment-approval-threshold", 0, "Balance adjustments above this amount need a second admin's approval, 0 disables approval")
+	flag.StringVar(&cfg.PasswordHashAlgorithm, "password-hash", "argon2id", "Password hash algorithm: argon2id or bcrypt")
+	flag.IntVar(&cfg.BcryptCost, "bcrypt-cost", 14, "bcrypt cost of password hashes")
+	flag.UintVar(&cfg.Argon2Memory, "argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.UintVar(&cfg.Argon2Iterations, "argon2-iterations", 3, "argon2id iterations")
+	flag.UintVar(&cfg.Argon2Parallelism, "argon2-parallelism", 2, "argon2id parallelism")
+	flag.IntVar(&cfg.HashWorkers, "hash-workers", runtime.NumCPU(), "Number of concurrent password hashing workers")
+	flag.IntVar(&cfg.HashQueueSize, "hash-queue-size", 64, "Password hashing requests waiting for a worker before 503 is returned")
+	flag.StringVar(&cfg.ResetDelivery, "reset-delivery", "log", "Password reset token delivery: log or file")
+	flag.StringVar(&cfg.ResetDeliveryFile, "reset-delivery-file", "", "File to append password reset tokens to")
+	flag.DurationVar(&cfg.PasswordResetTTL, "password-reset-ttl", 30*time.Minute, "Password reset token lifetime")
+	flag.StringVar(&cfg.AccountRetention, "account-retention", "anonymize", "What to do with orders and withdrawals of deleted accounts: anonymize or delete")
+	flag.StringVar(&cfg.WebhookAllowedHosts, "webhook-allowed-hosts", "", "Comma separated webhook hosts allowed to resolve to loopback or private addresses, e.g. receivers on the internal network")
 	flag.Parse()
 	err := env.Parse(&cfg)
 	if err != nil {
 		return Config{}, err
 	}
-	if cfg.SecretKey == "" {
-		logger.Log.Warn("secret key is empty")
+	if err = cfg.validate(); err != nil {
+		return Config{}, err
 	}
 	return cfg, nil
+}
 
+// validate checks the settings that can't be used as they are.
+func (cfg Config) validate() error {
+	if cfg.Storage != "postgres" && cfg.Storage != "memory" {
+		return fmt.Errorf("invalid storage backend: %q", cfg.Storage)
+	}
+	if cfg.DBMaxConns < 1 || cfg.DBMaxConns > math.MaxInt32 || cfg.DBMinConns < 0 || cfg.DBMinConns > cfg.DBMaxConns {
+		return fmt.Errorf("database connections must be 0 <= min <= max and max >= 1")
+	}
+	switch cfg.DBStatementCacheMode {
+	case "cache_statement", "cache_describe", "describe_exec", "exec", "simple_protocol":
+	default:
+		return fmt.Errorf("invalid database statement cache mode: %q", cfg.DBStatementCacheMode)
+	}
+	if cfg.DBStatementCacheSize < 0 || (cfg.DBStatementCacheSize == 0 && strings.HasPrefix(cfg.DBStatementCacheMode, "cache_")) {
+		return fmt.Errorf("statement cache mode %q needs a positive cache size", cfg.DBStatementCacheMode)
+	}
+	if cfg.DBConnectAttempts < 1 {
+		return fmt.Errorf("at least one database connect attempt is required")
+	}
+	switch cfg.CookieSameSite {
+	case "strict", "lax", "none":
+	default:
+		return fmt.Errorf("invalid cookie SameSite mode: %q", cfg.CookieSameSite)
+	}
+	if cfg.AccountRetention != "anonymize" && cfg.AccountRetention != "delete" {
+		return fmt.Errorf("invalid account retention policy: %q", cfg.AccountRetention)
+	}
+	if cfg.AdjustmentThreshold < 0 {
+		return fmt.Errorf("adjustment approval threshold must not be negative")
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > math.MaxUint8 || cfg.Argon2Iterations < 1 ||
+		cfg.Argon2Memory < 8*cfg.Argon2Parallelism || cfg.Argon2Memory > math.MaxUint32 {
+		return fmt.Errorf("invalid argon2id parameters")
+	}
+	if cfg.HashWorkers < 1 {
+		return fmt.Errorf("at least one hash worker is required")
+	}
+	// The secret key is optional with a signing key file, but must be strong if it's still accepted.
+	if (cfg.SigningKeyFile == "" || cfg.SecretKey != "") && len(cfg.SecretKey) < minSecretKeyLength {
+		if !cfg.DevMode {
+			return fmt.Errorf("secret key must be at least %d bytes long", minSecretKeyLength)
+		}
+		logger.Log.Warn("secret key is weak, only acceptable in development mode")
+	}
+	return nil
 }
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Config{
+		Storage:              "postgres",
+		DBMaxConns:           10,
+		DBStatementCacheMode: "cache_statement",
+		DBStatementCacheSize: 512,
+		DBConnectAttempts:    5,
+		CookieSameSite:       "lax",
+		AccountRetention:     "anonymize",
+		BcryptCost:           10,
+		Argon2Memory:         64 * 1024,
+		Argon2Iterations:     1,
+		Argon2Parallelism:    4,
+		HashWorkers:          1,
+		SecretKey:            strings.Repeat("k", minSecretKeyLength),
+	}
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		valid  bool
+	}{
+		{"defaults", func(cfg *Config) {}, true},
+		{"memory storage", func(cfg *Config) { cfg.Storage = "memory" }, true},
+		{"unknown storage", func(cfg *Config) { cfg.Storage = "mysql" }, false},
+		{"min conns up to max", func(cfg *Config) { cfg.DBMinConns = 10 }, true},
+		{"min conns above max", func(cfg *Config) { cfg.DBMinConns = 11 }, false},
+		{"negative min conns", func(cfg *Config) { cfg.DBMinConns = -1 }, false},
+		{"no max conns", func(cfg *Config) { cfg.DBMaxConns = 0 }, false},
+		{"max conns overflowing int32", func(cfg *Config) { cfg.DBMaxConns = math.MaxInt32 + 1 }, false},
+		{"unknown statement cache mode", func(cfg *Config) { cfg.DBStatementCacheMode = "prepare" }, false},
+		{"cache mode without cache size", func(cfg *Config) { cfg.DBStatementCacheSize = 0 }, false},
+		{"exec mode without cache size", func(cfg *Config) {
+			cfg.DBStatementCacheMode = "simple_protocol"
+			cfg.DBStatementCacheSize = 0
+		}, true},
+		{"negative cache size", func(cfg *Config) {
+			cfg.DBStatementCacheMode = "exec"
+			cfg.DBStatementCacheSize = -1
+		}, false},
+		{"no connect attempts", func(cfg *Config) { cfg.DBConnectAttempts = 0 }, false},
+		{"unknown SameSite mode", func(cfg *Config) { cfg.CookieSameSite = "relaxed" }, false},
+		{"unknown retention policy", func(cfg *Config) { cfg.AccountRetention = "keep" }, false},
+		{"no hash workers", func(cfg *Config) { cfg.HashWorkers = 0 }, false},
+		{"weak secret key", func(cfg *Config) { cfg.SecretKey = "secret" }, false},
+		{"weak secret key in dev mode", func(cfg *Config) {
+			cfg.SecretKey = "secret"
+			cfg.DevMode = true
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			if tt.valid {
+				require.NoError(t, cfg.validate())
+			} else {
+				require.Error(t, cfg.validate())
+			}
+		})
+	}
+}
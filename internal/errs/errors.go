@@ -2,6 +2,9 @@ package errs
 
 import (
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 )
 
 type HTTPError struct {
@@ -24,11 +27,92 @@ func (e HTTPError) Code() int {
 	return e.code
 }
 
+// ValidationError describes invalid request fields, keyed by field name.
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func NewValidationError() *ValidationError {
+	return &ValidationError{Fields: make(map[string]string)}
+}
+
+func (e *ValidationError) Add(field string, msg string) {
+	if _, ok := e.Fields[field]; !ok {
+		e.Fields[field] = msg
+	}
+}
+
+// Err returns nil if no fields have been reported.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, msg := range e.Fields {
+		fields = append(fields, field+": "+msg)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+func (e *ValidationError) Code() int {
+	return http.StatusBadRequest
+}
+
+// RetryAfterError tells the client when the request may be repeated.
+type RetryAfterError struct {
+	*HTTPError
+	RetryAfter time.Duration
+}
+
+func NewRetryAfterError(err *HTTPError, retryAfter time.Duration) *RetryAfterError {
+	return &RetryAfterError{HTTPError: err, RetryAfter: retryAfter}
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.HTTPError
+}
+
 var (
 	ErrUserExists               = NewHTTPError("user is already exists", http.StatusConflict)
 	ErrUserCredentials          = NewHTTPError("incorrect login or password", http.StatusUnauthorized)
+	ErrInvalidRefreshToken      = NewHTTPError("invalid refresh token", http.StatusUnauthorized)
+	ErrWrongPassword            = NewHTTPError("incorrect password", http.StatusForbidden)
+	ErrInvalidResetToken        = NewHTTPError("invalid or expired reset token", http.StatusBadRequest)
+	ErrAccountBlocked           = NewHTTPError("account is blocked", http.StatusForbidden)
+	ErrUserNotFound             = NewHTTPError("user not found", http.StatusNotFound)
+	ErrInvalidChallenge         = NewHTTPError("invalid or expired challenge", http.StatusUnauthorized)
+	ErrInvalidTOTPCode          = NewHTTPError("invalid two-factor code", http.StatusUnauthorized)
+	ErrTOTPRequired             = NewHTTPError("two-factor code required", http.StatusForbidden)
+	ErrTOTPEnabled              = NewHTTPError("two-factor authentication is already enabled", http.StatusConflict)
+	ErrTOTPNotEnrolled          = NewHTTPError("two-factor authentication is not enrolled", http.StatusConflict)
+	ErrSessionNotFound          = NewHTTPError("session not found", http.StatusNotFound)
+	ErrTooManyLoginAttempts     = NewHTTPError("too many failed login attempts", http.StatusTooManyRequests)
+	ErrLockoutNotFound          = NewHTTPError("lockout not found", http.StatusNotFound)
 	ErrInvalidOrderNum          = NewHTTPError("invalid order number", http.StatusUnprocessableEntity)
 	ErrOrderUploadedByUser      = NewHTTPError("order uploaded by user", http.StatusOK)
 	ErrOrderUploadedByOtherUser = NewHTTPError("order uploaded by other user", http.StatusConflict)
 	ErrBalanceNotEnoughPoints   = NewHTTPError("not enough points", http.StatusPaymentRequired)
+	ErrOrderNotFound            = NewHTTPError("order not found", http.StatusNotFound)
+	ErrOrderNotCancellable      = NewHTTPError("order is already being processed", http.StatusConflict)
+	ErrOrderNotRequeueable      = NewHTTPError("order accrual is already credited", http.StatusConflict)
+	ErrInvalidWebhook           = NewHTTPError("invalid webhook", http.StatusBadRequest)
+	ErrWebhookNotFound          = NewHTTPError("webhook not found", http.StatusNotFound)
+	ErrAdjustmentNotFound       = NewHTTPError("adjustment not found", http.StatusNotFound)
+	ErrAdjustmentReviewed       = NewHTTPError("adjustment is already reviewed", http.StatusConflict)
+	ErrAdjustmentSelfApproval   = NewHTTPError("adjustment must be reviewed by another admin", http.StatusForbidden)
+	ErrAdjustmentOwnBalance     = NewHTTPError("admins can't adjust their own balance", http.StatusForbidden)
+	ErrInvalidAPIKey            = NewHTTPError("invalid api key", http.StatusBadRequest)
+	ErrAPIKeyNotFound           = NewHTTPError("api key not found", http.StatusNotFound)
+	ErrInvalidPartner           = NewHTTPError("invalid partner", http.StatusBadRequest)
+	ErrPartnerNotFound          = NewHTTPError("partner not found", http.StatusNotFound)
+	ErrCustomerNotFound         = NewHTTPError("customer not found", http.StatusNotFound)
+	ErrInvalidCustomer          = NewHTTPError("invalid customer", http.StatusBadRequest)
+	ErrServiceBusy              = NewHTTPError("server is busy, try again later", http.StatusServiceUnavailable)
+	ErrEmptyBatch               = NewHTTPError("empty batch", http.StatusBadRequest)
+	ErrBatchTooLarge            = NewHTTPError("batch is too large", http.StatusRequestEntityTooLarge)
 )
@@ -0,0 +1,62 @@
+package events
+
+import (
+	"github.com/kuznet1/gophermart/internal/model"
+	"sync"
+)
+
+const subscriberBufferSize = 16
+
+// Broker fans out order events to the subscribers of the order owner.
+// A subscriber that does not keep up is dropped: its channel is closed and
+// the client is expected to reconnect and replay missed events from storage.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan model.OrderEvent]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[int]map[chan model.OrderEvent]struct{}),
+	}
+}
+
+func (b *Broker) Subscribe(userID int) (<-chan model.OrderEvent, func()) {
+	ch := make(chan model.OrderEvent, subscriberBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan model.OrderEvent]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(userID, ch)
+	}
+}
+
+func (b *Broker) Publish(event model.OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			b.remove(event.UserID, ch)
+		}
+	}
+}
+
+func (b *Broker) remove(userID int, ch chan model.OrderEvent) {
+	if _, ok := b.subscribers[userID][ch]; !ok {
+		return
+	}
+	delete(b.subscribers[userID], ch)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+	close(ch)
+}
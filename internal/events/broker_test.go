@@ -0,0 +1,49 @@
+package events
+
+import (
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+
+	t.Run("fan out to the owner only", func(t *testing.T) {
+		first, unsubscribeFirst := b.Subscribe(1)
+		defer unsubscribeFirst()
+		second, unsubscribeSecond := b.Subscribe(1)
+		defer unsubscribeSecond()
+		other, unsubscribeOther := b.Subscribe(2)
+		defer unsubscribeOther()
+
+		event := model.OrderEvent{ID: 1, UserID: 1, Order: "12345678903", Status: "PROCESSED"}
+		b.Publish(event)
+		require.Equal(t, event, <-first)
+		require.Equal(t, event, <-second)
+		require.Empty(t, other)
+	})
+
+	t.Run("slow subscriber is dropped", func(t *testing.T) {
+		ch, unsubscribe := b.Subscribe(3)
+		defer unsubscribe()
+
+		for i := 0; i <= subscriberBufferSize; i++ {
+			b.Publish(model.OrderEvent{ID: int64(i), UserID: 3})
+		}
+		for i := 0; i < subscriberBufferSize; i++ {
+			<-ch
+		}
+		_, ok := <-ch
+		require.False(t, ok, "channel is closed")
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		ch, unsubscribe := b.Subscribe(4)
+		unsubscribe()
+		_, ok := <-ch
+		require.False(t, ok)
+		unsubscribe()
+		b.Publish(model.OrderEvent{UserID: 4})
+	})
+}
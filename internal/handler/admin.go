@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.svc.SearchUsers(r.Context(), r.URL.Query().Get("login"))
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, users, http.StatusOK)
+}
+
+func (h *Handler) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := model.AdminOrderFilter{Number: query.Get("number"), Status: query.Get("status")}
+	if userID := query.Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			http.Error(w, "invalid user_id", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = id
+	}
+
+	orders, err := h.svc.SearchOrders(r.Context(), filter)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, orders, http.StatusOK)
+}
+
+func (h *Handler) GetUserBalance(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrUserNotFound, w)
+		return
+	}
+
+	balance, err := h.svc.GetUserBalance(r.Context(), userID)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, balance, http.StatusOK)
+}
+
+func (h *Handler) BlockUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserBlocked(w, r, true)
+}
+
+func (h *Handler) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserBlocked(w, r, false)
+}
+
+func (h *Handler) setUserBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrUserNotFound, w)
+		return
+	}
+
+	if err = h.svc.SetUserBlocked(r.Context(), userID, blocked); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RequeueOrder(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.RequeueOrder(r.Context(), chi.URLParam(r, "number")); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) CreateAdjustment(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrUserNotFound, w)
+		return
+	}
+
+	var req model.AdjustmentRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adjustment, err := h.svc.CreateAdjustment(r.Context(), userID, req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, adjustment, http.StatusCreated)
+}
+
+func (h *Handler) SearchAdjustments(w http.ResponseWriter, r *http.Request) {
+	adjustments, err := h.svc.SearchAdjustments(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, adjustments, http.StatusOK)
+}
+
+func (h *Handler) ApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	h.reviewAdjustment(w, r, true)
+}
+
+func (h *Handler) RejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	h.reviewAdjustment(w, r, false)
+}
+
+func (h *Handler) reviewAdjustment(w http.ResponseWriter, r *http.Request, approve bool) {
+	adjustmentID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrAdjustmentNotFound, w)
+		return
+	}
+
+	adjustment, err := h.svc.ReviewAdjustment(r.Context(), adjustmentID, approve)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, adjustment, http.StatusOK)
+}
+
+func (h *Handler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	login, ip := r.URL.Query().Get("login"), r.URL.Query().Get("ip")
+	if err := h.svc.UnlockLogin(r.Context(), login, ip); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/kuznet1/gophermart/internal/config"
 	"github.com/kuznet1/gophermart/internal/errs"
 	"github.com/kuznet1/gophermart/internal/logger"
 	"github.com/kuznet1/gophermart/internal/middleware"
@@ -11,34 +15,107 @@ import (
 	"github.com/kuznet1/gophermart/internal/service"
 	"go.uber.org/zap"
 	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxBatchBodySize     = 1 << 20
+	sseHeartbeatInterval = 15 * time.Second
+	maxUserAgentSize     = 512
 )
 
 type Handler struct {
+	cfg  config.Config
 	svc  *service.Service
 	auth *middleware.Auth
 }
 
-func NewHandler(svc *service.Service, auth *middleware.Auth) *Handler {
-	return &Handler{svc, auth}
+func NewHandler(cfg config.Config, svc *service.Service, auth *middleware.Auth) *Handler {
+	return &Handler{cfg, svc, auth}
 }
 
 func (h *Handler) Router() *chi.Mux {
 	r := chi.NewMux()
+	if h.cfg.TrustProxyHeaders {
+		r.Use(chimiddleware.RealIP)
+	}
 	r.Use(middleware.Compression)
 
+	r.Get("/.well-known/jwks.json", h.JWKS)
+
 	r.Route("/api", func(r chi.Router) {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(h.auth.Authentication)
+			r.Use(h.auth.CSRF)
+			r.Use(middleware.RequireRole(model.RoleAdmin))
+			r.Get("/users", h.SearchUsers)
+			r.Get("/users/{id}/balance", h.GetUserBalance)
+			r.Post("/users/{id}/block", h.BlockUser)
+			r.Delete("/users/{id}/block", h.UnblockUser)
+			r.Get("/orders", h.SearchOrders)
+			r.Post("/orders/{number}/requeue", h.RequeueOrder)
+			r.Post("/users/{id}/adjustments", h.CreateAdjustment)
+			r.Get("/adjustments", h.SearchAdjustments)
+			r.Post("/adjustments/{id}/approve", h.ApproveAdjustment)
+			r.Post("/adjustments/{id}/reject", h.RejectAdjustment)
+			r.Delete("/lockouts", h.UnlockLogin)
+			r.Post("/partners", h.CreatePartner)
+			r.Get("/partners", h.GetPartners)
+			r.Delete("/partners/{id}", h.RevokePartner)
+		})
+
+		r.Route("/partner", func(r chi.Router) {
+			r.Use(h.auth.PartnerSignature)
+			r.Put("/customers", h.LinkPartnerCustomer)
+			r.Post("/orders", h.NewPartnerOrder)
+		})
+
 		r.Route("/user", func(r chi.Router) {
 			r.Post("/register", h.Register)
 			r.Post("/login", h.Login)
+			r.Post("/login/2fa", h.CompleteLogin)
+			r.With(h.auth.RefreshCSRF).Post("/refresh", h.Refresh)
+			r.Post("/password/reset-request", h.RequestPasswordReset)
+			r.Post("/password/reset", h.ResetPassword)
 
 			r.Group(func(r chi.Router) {
 				r.Use(h.auth.Authentication)
-				r.Post("/orders", h.NewOrder)
-				r.Get("/orders", h.GetOrders)
-				r.Get("/balance", h.GetBalance)
-				r.Post("/balance/withdraw", h.Withdraw)
-				r.Get("/withdrawals", h.GetWithdrawals)
+				r.Use(h.auth.CSRF)
+
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequireSession)
+					r.Post("/logout", h.Logout)
+					r.Post("/password", h.ChangePassword)
+					r.Delete("/", h.DeleteAccount)
+					r.Post("/webhooks", h.CreateWebhook)
+					r.Get("/webhooks", h.GetWebhooks)
+					r.Delete("/webhooks/{id}", h.DeleteWebhook)
+					r.Get("/webhooks/{id}/deliveries", h.GetWebhookDeliveries)
+					r.Post("/api-keys", h.CreateAPIKey)
+					r.Get("/api-keys", h.GetAPIKeys)
+					r.Delete("/api-keys/{id}", h.RevokeAPIKey)
+					r.Post("/2fa/enroll", h.EnrollTOTP)
+					r.Post("/2fa/confirm", h.ConfirmTOTP)
+					r.Post("/2fa/disable", h.DisableTOTP)
+					r.Get("/sessions", h.GetSessions)
+					r.Delete("/sessions/{id}", h.RevokeSession)
+				})
+
+				r.With(middleware.RequireScope(model.ScopeOrdersWrite)).Post("/orders", h.NewOrder)
+				r.With(middleware.RequireScope(model.ScopeOrdersWrite)).Post("/orders/batch", h.NewOrders)
+				r.With(middleware.RequireScope(model.ScopeOrdersRead)).Get("/orders", h.GetOrders)
+				r.With(middleware.RequireScope(model.ScopeOrdersRead)).Get("/orders/stream", h.StreamOrders)
+				r.With(middleware.RequireScope(model.ScopeOrdersWrite)).Delete("/orders/{number}", h.CancelOrder)
+				r.With(middleware.RequireScope(model.ScopeBalanceRead)).Get("/balance", h.GetBalance)
+				r.With(middleware.RequireScope(model.ScopeBalanceRead)).Get("/balance/adjustments", h.GetBalanceAdjustments)
+				r.With(middleware.RequireScope(model.ScopeWithdrawalsWrite)).Post("/balance/withdraw", h.Withdraw)
+				r.With(middleware.RequireScope(model.ScopeWithdrawalsRead)).Get("/withdrawals", h.GetWithdrawals)
 			})
 
 		})
@@ -46,6 +123,11 @@ func (h *Handler) Router() *chi.Mux {
 	return r
 }
 
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respJSON(w, h.auth.JWKS(), http.StatusOK)
+}
+
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	var user model.UserCredentials
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -53,22 +135,13 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.svc.Register(user)
-	var httpErr *errs.HTTPError
-	if errors.As(err, &httpErr) {
-		http.Error(w, httpErr.Error(), httpErr.Code())
-		return
-	}
-
+	tokens, err := h.svc.Register(r.Context(), user, clientInfo(r))
 	if err != nil {
-		internalError(err, w)
+		handleError(err, w)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  middleware.AuthCookieName,
-		Value: token,
-	})
+	h.respTokens(w, tokens)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
@@ -78,22 +151,169 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.svc.Login(user)
-	var httpErr *errs.HTTPError
-	if errors.As(err, &httpErr) {
-		http.Error(w, httpErr.Error(), httpErr.Code())
+	result, err := h.svc.Login(r.Context(), user, clientInfo(r))
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	if result.Challenge != nil {
+		respJSON(w, result.Challenge, http.StatusAccepted)
+		return
+	}
+	h.respTokens(w, *result.Tokens)
+}
+
+func (h *Handler) CompleteLogin(w http.ResponseWriter, r *http.Request) {
+	var req model.ChallengeResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.svc.CompleteLogin(req, clientInfo(r))
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	h.respTokens(w, tokens)
+}
+
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := h.svc.EnrollTOTP(r.Context())
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, enrollment, http.StatusOK)
+}
+
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req model.TOTPCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	codes, err := h.svc.ConfirmTOTP(r.Context(), req)
 	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, codes, http.StatusOK)
+}
+
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req model.TOTPCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.DisableTOTP(r.Context(), req); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var refreshToken string
+	if cookie, err := r.Cookie(middleware.RefreshCookieName); err == nil {
+		refreshToken = cookie.Value
+	} else {
+		var req model.RefreshRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		refreshToken = req.RefreshToken
+	}
+
+	tokens, err := h.svc.Refresh(refreshToken)
+	if errors.Is(err, errs.ErrInvalidRefreshToken) {
+		h.auth.ClearAuthCookies(w)
+	}
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	h.respTokens(w, tokens)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.Logout(r.Context()); err != nil {
 		internalError(err, w)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  middleware.AuthCookieName,
-		Value: token,
-	})
+	h.auth.ClearAuthCookies(w)
+}
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.ChangePassword(r.Context(), req, clientInfo(r)); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.RequestPasswordReset(req); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.ResetPassword(r.Context(), req); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req model.AccountDeletion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.DeleteAccount(r.Context(), req, clientInfo(r)); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	h.auth.ClearAuthCookies(w)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
@@ -105,21 +325,50 @@ func (h *Handler) NewOrder(w http.ResponseWriter, r *http.Request) {
 	order := string(body)
 
 	err = h.svc.NewOrder(r.Context(), order)
-	var httpErr *errs.HTTPError
-	if errors.As(err, &httpErr) {
-		http.Error(w, httpErr.Error(), httpErr.Code())
+	if err != nil {
+		handleError(err, w)
 		return
 	}
 
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) NewOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := decodeOrderBatch(http.MaxBytesReader(w, r.Body, maxBatchBodySize), r.Header.Get("Content-Type"))
 	if err != nil {
-		internalError(err, w)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	results, err := h.svc.NewOrders(r.Context(), orders)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, results, http.StatusOK)
+}
+
+func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	err := h.svc.CancelOrder(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	notModified, err := h.checkETag(w, r, "orders")
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+	if notModified {
+		return
+	}
+
 	orders, err := h.svc.GetOrders(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -134,7 +383,67 @@ func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	respJSON(w, orders, status)
 }
 
+func (h *Handler) StreamOrders(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastEventID int64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if lastEventID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	orderEvents, err := h.svc.OrderEvents(r.Context(), lastEventID)
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-orderEvents:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Log.Error("failed to marshal order event", zap.Error(err))
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", event.ID, data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
 func (h *Handler) GetBalance(w http.ResponseWriter, r *http.Request) {
+	notModified, err := h.checkETag(w, r, "balance")
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+	if notModified {
+		return
+	}
+
 	balance, err := h.svc.GetBalance(r.Context())
 	if err != nil {
 		internalError(err, w)
@@ -152,21 +461,48 @@ func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := h.svc.Withdraw(r.Context(), withdraw)
-	var httpErr *errs.HTTPError
-	if errors.As(err, &httpErr) {
-		http.Error(w, httpErr.Error(), httpErr.Code())
+	if err != nil {
+		handleError(err, w)
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetBalanceAdjustments(w http.ResponseWriter, r *http.Request) {
+	notModified, err := h.checkETag(w, r, "adjustments")
 	if err != nil {
 		internalError(err, w)
 		return
 	}
+	if notModified {
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	adjustments, err := h.svc.GetBalanceAdjustments(r.Context())
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+
+	status := http.StatusOK
+	if len(adjustments) == 0 {
+		status = http.StatusNoContent
+	}
+
+	respJSON(w, adjustments, status)
 }
 
 func (h *Handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
+	notModified, err := h.checkETag(w, r, "withdrawals")
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+	if notModified {
+		return
+	}
+
 	withdrawals, err := h.svc.GetWithdrawals(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -181,6 +517,231 @@ func (h *Handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	respJSON(w, withdrawals, status)
 }
 
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req model.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.svc.CreateWebhook(r.Context(), req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, webhook, http.StatusCreated)
+}
+
+func (h *Handler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.svc.GetWebhooks(r.Context())
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+
+	respJSON(w, webhooks, http.StatusOK)
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrWebhookNotFound, w)
+		return
+	}
+
+	err = h.svc.DeleteWebhook(r.Context(), webhookID)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrWebhookNotFound, w)
+		return
+	}
+
+	deliveries, err := h.svc.GetWebhookDeliveries(r.Context(), webhookID)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, deliveries, http.StatusOK)
+}
+
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.svc.GetSessions(r.Context())
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+
+	respJSON(w, sessions, http.StatusOK)
+}
+
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.RevokeSession(r.Context(), chi.URLParam(r, "id")); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req model.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.svc.CreateAPIKey(r.Context(), req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, key, http.StatusCreated)
+}
+
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.svc.GetAPIKeys(r.Context())
+	if err != nil {
+		internalError(err, w)
+		return
+	}
+
+	respJSON(w, keys, http.StatusOK)
+}
+
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrAPIKeyNotFound, w)
+		return
+	}
+
+	if err = h.svc.RevokeAPIKey(r.Context(), keyID); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clientInfo(r *http.Request) model.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentSize {
+		userAgent = userAgent[:maxUserAgentSize]
+	}
+	return model.ClientInfo{IP: ip, UserAgent: strings.ToValidUTF8(userAgent, "")}
+}
+
+// respTokens hands the tokens out both as cookies for browsers and in the
+// Authorization header and JSON body for clients without a cookie jar.
+func (h *Handler) respTokens(w http.ResponseWriter, tokens model.Tokens) {
+	h.auth.SetAuthCookies(w, tokens)
+	w.Header().Set("Authorization", "Bearer "+tokens.AccessToken)
+	respJSON(w, tokens, http.StatusOK)
+}
+
+// checkETag sets the ETag of the resource and responds with 304 Not Modified
+// if it matches the If-None-Match request header.
+func (h *Handler) checkETag(w http.ResponseWriter, r *http.Request, resource string) (bool, error) {
+	etag, err := h.svc.ETag(r.Context(), resource)
+	if err != nil {
+		return false, err
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false, nil
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true, nil
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeOrderBatch accepts either a JSON array of order numbers (strings or numbers)
+// or a CSV body with one or more order numbers per line.
+func decodeOrderBatch(body io.Reader, contentType string) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "text/csv" {
+		reader := csv.NewReader(body)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		var orders []string
+		for _, record := range records {
+			for _, field := range record {
+				if field = strings.TrimSpace(field); field != "" {
+					orders = append(orders, field)
+				}
+			}
+		}
+		return orders, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&items); err != nil {
+		return nil, err
+	}
+	orders := make([]string, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal(item, &orders[i]); err != nil {
+			orders[i] = string(item)
+		}
+	}
+	return orders, nil
+}
+
+// handleError responds with the status of a client error or hides the details of an internal one.
+func handleError(err error, w http.ResponseWriter) {
+	var retryErr *errs.RetryAfterError
+	if errors.As(err, &retryErr) {
+		seconds := int(math.Ceil(retryErr.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	}
+
+	var validationErr *errs.ValidationError
+	if errors.As(err, &validationErr) {
+		respJSON(w, validationErr, validationErr.Code())
+		return
+	}
+
+	var httpErr *errs.HTTPError
+	if errors.As(err, &httpErr) {
+		http.Error(w, httpErr.Error(), httpErr.Code())
+		return
+	}
+
+	internalError(err, w)
+}
+
 func internalError(err error, w http.ResponseWriter) {
 	logger.Log.Error(err.Error(), zap.Error(err))
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
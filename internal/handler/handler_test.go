@@ -1,17 +1,26 @@
 package handler
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/events"
 	"github.com/kuznet1/gophermart/internal/middleware"
 	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/notify"
+	"github.com/kuznet1/gophermart/internal/password"
 	"github.com/kuznet1/gophermart/internal/repository"
 	"github.com/kuznet1/gophermart/internal/service"
+	"github.com/kuznet1/gophermart/internal/totp"
+	"github.com/kuznet1/gophermart/internal/webhook"
 	"github.com/stretchr/testify/require"
 	"github.com/theplant/luhn"
+	"golang.org/x/crypto/bcrypt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -27,10 +36,12 @@ func generateUserName() string {
 	return fmt.Sprintf("user%d", time.Now().Unix())
 }
 
-func generateOrderID() int {
-	base := int(time.Now().Unix())
-	check := luhn.CalculateLuhn(base)
-	return base*10 + check
+func generateOrderID() string {
+	return luhnNumber(int(time.Now().Unix()))
+}
+
+func luhnNumber(base int) string {
+	return strconv.Itoa(base*10 + luhn.CalculateLuhn(base))
 }
 
 func TestFlow(t *testing.T) {
@@ -53,6 +64,21 @@ func TestFlow(t *testing.T) {
 		cookies = resp.Cookies()
 	})
 
+	t.Run("register invalid credentials", func(t *testing.T) {
+		cred := model.UserCredentials{Login: "", Password: "pas"}
+		b, _ := json.Marshal(cred)
+		resp, err := http.Post(ts.URL+"/api/user/register", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var verr errs.ValidationError
+		err = json.NewDecoder(resp.Body).Decode(&verr)
+		require.NoError(t, err)
+		require.Contains(t, verr.Fields, "login")
+		require.Contains(t, verr.Fields, "password")
+	})
+
 	t.Run("login", func(t *testing.T) {
 		cred := model.UserCredentials{Login: userName, Password: "pass1"}
 		b, _ := json.Marshal(cred)
@@ -63,8 +89,29 @@ func TestFlow(t *testing.T) {
 		cookies = resp.Cookies()
 	})
 
+	t.Run("bearer", func(t *testing.T) {
+		cred := model.UserCredentials{Login: userName, Password: "pass1"}
+		b, _ := json.Marshal(cred)
+		resp, err := http.Post(ts.URL+"/api/user/login", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var tokens model.Tokens
+		err = json.NewDecoder(resp.Body).Decode(&tokens)
+		require.NoError(t, err)
+		require.Equal(t, "Bearer "+tokens.AccessToken, resp.Header.Get("Authorization"))
+
+		req, _ := http.NewRequest("GET", ts.URL+"/api/user/balance", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
 	t.Run("new order", func(t *testing.T) {
-		req, _ := http.NewRequest("POST", ts.URL+"/api/user/orders", bytes.NewBufferString(strconv.Itoa(orderID)))
+		req, _ := http.NewRequest("POST", ts.URL+"/api/user/orders", bytes.NewBufferString(orderID))
 		for _, c := range cookies {
 			req.AddCookie(c)
 		}
@@ -91,6 +138,26 @@ func TestFlow(t *testing.T) {
 		require.Equal(t, orderID, orders[0].Order)
 	})
 
+	t.Run("get orders not modified", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/user/orders", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		etag := resp.Header.Get("ETag")
+		require.NotEmpty(t, etag)
+
+		req.Header.Set("If-None-Match", etag)
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		require.Equal(t, etag, resp.Header.Get("ETag"))
+	})
+
 	t.Run("get balance", func(t *testing.T) {
 		req, _ := http.NewRequest("GET", ts.URL+"/api/user/balance", nil)
 		for _, c := range cookies {
@@ -141,31 +208,368 @@ func TestFlow(t *testing.T) {
 		require.NotEmpty(t, withdrawals)
 		require.Equal(t, orderID, withdrawals[0].Order)
 	})
+
+	t.Run("new orders batch", func(t *testing.T) {
+		newOrderID := luhnNumber(int(time.Now().Unix()) + 1)
+		b, _ := json.Marshal([]string{orderID, newOrderID, "123"})
+		req, _ := http.NewRequest("POST", ts.URL+"/api/user/orders/batch", bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		defer resp.Body.Close()
+		var results []model.OrderBatchResult
+		err = json.NewDecoder(resp.Body).Decode(&results)
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		require.Equal(t, http.StatusOK, results[0].Code)
+		require.Equal(t, http.StatusAccepted, results[1].Code)
+		require.Equal(t, http.StatusUnprocessableEntity, results[2].Code)
+	})
+
+	t.Run("order stream replay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/user/orders/stream", nil)
+		req.Header.Set("Last-Event-ID", "1")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		lines := bufio.NewScanner(resp.Body)
+		var frame []string
+		for len(frame) < 3 && lines.Scan() {
+			frame = append(frame, lines.Text())
+		}
+		require.Len(t, frame, 3)
+		require.Equal(t, "id: 2", frame[0], "events after Last-Event-ID are replayed")
+		require.Equal(t, "event: order", frame[1])
+		require.Contains(t, frame[2], `"status":"PROCESSED"`)
+	})
+
+	t.Run("cancel processed order", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", ts.URL+"/api/user/orders/"+orderID, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		// a session of its own, since reusing the refresh token revokes the session
+		cred := model.UserCredentials{Login: userName, Password: "pass1"}
+		b, _ := json.Marshal(cred)
+		resp, err := http.Post(ts.URL+"/api/user/login", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		req, _ := http.NewRequest("POST", ts.URL+"/api/user/refresh", nil)
+		for _, c := range resp.Cookies() {
+			req.AddCookie(c)
+		}
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		req.Header.Set("Origin", "https://evil.example")
+		forged, err := client.Do(req)
+		require.NoError(t, err)
+		forged.Body.Close()
+		require.Equal(t, http.StatusForbidden, forged.StatusCode, "cross-site refresh")
+
+		req.Header.Del("Sec-Fetch-Site")
+		req.Header.Del("Origin")
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		reused, err := client.Do(req)
+		require.NoError(t, err)
+		defer reused.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, reused.StatusCode)
+	})
+
+	t.Run("get balance adjustments", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/user/balance/adjustments", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		b, _ := json.Marshal(model.APIKeyRequest{Name: "pos", Scopes: []string{model.ScopeOrdersRead}})
+		req, _ := http.NewRequest("POST", ts.URL+"/api/user/api-keys", bytes.NewBuffer(b))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var key model.APIKey
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&key))
+		require.NotEmpty(t, key.Key)
+
+		for path, status := range map[string]int{
+			"/api/user/orders":   http.StatusOK,
+			"/api/user/balance":  http.StatusForbidden,
+			"/api/user/api-keys": http.StatusForbidden,
+		} {
+			req, _ = http.NewRequest("GET", ts.URL+path, nil)
+			req.Header.Set("Authorization", "Bearer "+key.Key)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, status, resp.StatusCode, path)
+		}
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		cred := model.UserCredentials{Login: userName, Password: "pass1"}
+		b, _ := json.Marshal(cred)
+		resp, err := http.Post(ts.URL+"/api/user/login", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		otherCookies := resp.Cookies()
+
+		getSessions := func(cookies []*http.Cookie) []model.Session {
+			req, _ := http.NewRequest("GET", ts.URL+"/api/user/sessions", nil)
+			req.Header.Set("User-Agent", "flow-test")
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			var sessions []model.Session
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+			return sessions
+		}
+
+		var otherID string
+		for _, session := range getSessions(otherCookies) {
+			if session.Current {
+				otherID = session.ID
+			}
+		}
+		require.NotEmpty(t, otherID)
+		require.Len(t, getSessions(cookies), len(getSessions(otherCookies)))
+
+		req, _ := http.NewRequest("DELETE", ts.URL+"/api/user/sessions/"+otherID, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		req, _ = http.NewRequest("GET", ts.URL+"/api/user/orders", nil)
+		for _, c := range otherCookies {
+			req.AddCookie(c)
+		}
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("admin api forbidden for users", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/admin/users?login="+userName, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		cred := model.UserCredentials{Login: userName, Password: "pass1"}
+		b, _ := json.Marshal(cred)
+		resp, err := http.Post(ts.URL+"/api/user/login", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		cookies = resp.Cookies()
+
+		req, _ := http.NewRequest("POST", ts.URL+"/api/user/logout", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		req, _ = http.NewRequest("GET", ts.URL+"/api/user/orders", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("two-factor login", func(t *testing.T) {
+		post := func(path string, body any, cookies []*http.Cookie) *http.Response {
+			b, _ := json.Marshal(body)
+			req, _ := http.NewRequest("POST", ts.URL+path, bytes.NewBuffer(b))
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			return resp
+		}
+		cred := model.UserCredentials{Login: userName, Password: "pass1"}
+
+		resp := post("/api/user/login", cred, nil)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		cookies := resp.Cookies()
+
+		resp = post("/api/user/2fa/enroll", nil, cookies)
+		var enrollment model.TOTPEnrollment
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollment))
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		code, err := totp.Code(enrollment.Secret, time.Now())
+		require.NoError(t, err)
+		resp = post("/api/user/2fa/confirm", model.TOTPCode{Code: code}, cookies)
+		var recovery model.RecoveryCodes
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&recovery))
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Len(t, recovery.Codes, 10)
+
+		login := func() string {
+			resp := post("/api/user/login", cred, nil)
+			var challenge model.Challenge
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+			resp.Body.Close()
+			require.Equal(t, http.StatusAccepted, resp.StatusCode)
+			require.Empty(t, resp.Cookies())
+			return challenge.ChallengeToken
+		}
+
+		challenge := login()
+		resp = post("/api/user/login/2fa", model.ChallengeResponse{ChallengeToken: challenge, Code: code}, nil)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "replayed code")
+
+		resp = post("/api/user/login/2fa", model.ChallengeResponse{ChallengeToken: challenge, Code: recovery.Codes[0]}, nil)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "used challenge")
+
+		resp = post("/api/user/login/2fa", model.ChallengeResponse{ChallengeToken: login(), Code: recovery.Codes[0]}, nil)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		cookies = resp.Cookies()
+
+		resp = post("/api/user/login/2fa", model.ChallengeResponse{ChallengeToken: login(), Code: recovery.Codes[0]}, nil)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "used recovery code")
+
+		resp = post("/api/user/2fa/disable", model.TOTPCode{Code: recovery.Codes[1]}, cookies)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+}
+
+func TestLoginThrottle(t *testing.T) {
+	mux, err := newMux(func(cfg *config.Config) {
+		cfg.LoginMaxFailures = 4
+		cfg.LoginThrottleDelay = time.Minute
+		cfg.LoginLockoutDuration = 15 * time.Minute
+	})
+	require.NoError(t, err)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	login := func(password string) *http.Response {
+		b, _ := json.Marshal(model.UserCredentials{Login: "throttled", Password: password})
+		resp, err := http.Post(ts.URL+"/api/user/login", "application/json", bytes.NewBuffer(b))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	b, _ := json.Marshal(model.UserCredentials{Login: "throttled", Password: "pass1"})
+	resp, err := http.Post(ts.URL+"/api/user/register", "application/json", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for i := 0; i < 4; i++ {
+		require.Equal(t, http.StatusUnauthorized, login("wrong").StatusCode)
+	}
+	resp = login("pass1")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	require.Greater(t, retryAfter, 14*60)
+	require.LessOrEqual(t, retryAfter, 15*60)
 }
 
-func newMux() (*chi.Mux, error) {
+func newMux(configure ...func(cfg *config.Config)) (*chi.Mux, error) {
 	cfg := config.Config{
-		RunAddress:           ":8086",
-		AccrualSystemAddress: "http://localhost:8080",
-		DatabaseURI:          "postgres://postgres@localhost:5432/gophermart",
-		MigrationsPath:       "file://../../migrations",
+		RunAddress:            ":8086",
+		AccrualSystemAddress:  "http://localhost:8080",
+		Storage:               "memory",
+		PasswordMinLength:     5,
+		PasswordHashAlgorithm: "bcrypt",
+		BcryptCost:            bcrypt.MinCost,
+		AccessTokenTTL:        time.Minute,
+		RefreshTokenTTL:       time.Hour,
+		DevMode:               true,
+	}
+	for _, fn := range configure {
+		fn(&cfg)
 	}
 
-	db, err := repository.InitDBConnection(cfg)
+	repo := repository.NewMemRepo()
+	accrualClient := &accrualMock{repo: repo}
+	validator, err := service.NewCredentialsValidator(cfg)
 	if err != nil {
 		return nil, err
 	}
-
-	repo := repository.NewRepo(db)
-	accrualClient := &accrualMock{repo: repo}
-	auth := middleware.NewAuth(cfg)
-	svc := service.NewService(repo, auth, accrualClient)
-	s := NewHandler(svc, auth)
+	passwords, err := password.NewManager(cfg, password.NewPool(1, 8))
+	if err != nil {
+		return nil, err
+	}
+	auth, err := middleware.NewAuth(cfg, repo)
+	if err != nil {
+		return nil, err
+	}
+	svc := service.NewService(cfg, repo, auth, accrualClient, events.NewBroker(), webhook.NewDispatcher(cfg, repo), validator, passwords, notify.LogSender{})
+	s := NewHandler(cfg, svc, auth)
 	return s.Router(), nil
 }
 
 type accrualMock struct {
-	repo *repository.Repo
+	repo repository.AccrualQueue
 }
 
 func (p *accrualMock) Signal() {
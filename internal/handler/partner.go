@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) CreatePartner(w http.ResponseWriter, r *http.Request) {
+	var req model.PartnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	partner, err := h.svc.CreatePartner(r.Context(), req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, partner, http.StatusCreated)
+}
+
+func (h *Handler) GetPartners(w http.ResponseWriter, r *http.Request) {
+	partners, err := h.svc.GetPartners(r.Context())
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	respJSON(w, partners, http.StatusOK)
+}
+
+func (h *Handler) RevokePartner(w http.ResponseWriter, r *http.Request) {
+	partnerID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		handleError(errs.ErrPartnerNotFound, w)
+		return
+	}
+
+	if err = h.svc.RevokePartner(r.Context(), partnerID); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) LinkPartnerCustomer(w http.ResponseWriter, r *http.Request) {
+	var customer model.PartnerCustomer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.LinkPartnerCustomer(r.Context(), customer); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) NewPartnerOrder(w http.ResponseWriter, r *http.Request) {
+	var order model.PartnerOrder
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.svc.NewPartnerOrder(r.Context(), order); err != nil {
+		handleError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,26 @@
+package luhn
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		number string
+		valid  bool
+	}{
+		{"12345678903", true},
+		{"12345678904", false},
+		{"0", true},
+		{"4561261212345467", true},
+		{"12345678901234567890123459", true},
+		{"12345678901234567890123458", false},
+		{"", false},
+		{"-18", false},
+		{"1234 5678903", false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.valid, Valid(tt.number), tt.number)
+	}
+}
@@ -2,59 +2,176 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/secret"
+	"go.uber.org/zap"
 	"net/http"
+	"strings"
+	"time"
 )
 
 var AuthCookieName = "token"
 
+var RefreshCookieName = "refresh_token"
+
 type key int
 
-const UserIDKey key = iota
+const (
+	UserIDKey key = iota
+	SessionIDKey
+	RoleKey
+	AuthMethodKey
+	ScopesKey
+	PartnerIDKey
+)
+
+// Authentication methods stored in the request context under AuthMethodKey.
+const (
+	AuthMethodCookie = "cookie"
+	AuthMethodBearer = "bearer"
+	AuthMethodAPIKey = "api_key"
+)
+
+// APIKeyPrefix starts every API key, which tells API keys apart from JWTs in the Authorization header.
+const APIKeyPrefix = "gm_"
+
+type SessionChecker interface {
+	IsSessionActive(sessionID string) (bool, error)
+}
+
+type APIKeyChecker interface {
+	AuthenticateAPIKey(keyHash string) (int, []string, error)
+}
+
+// Store looks up the credentials the middleware authenticates requests with.
+type Store interface {
+	SessionChecker
+	APIKeyChecker
+	PartnerChecker
+}
 
 type Auth struct {
-	cfg config.Config
+	cfg   config.Config
+	keys  *keySet
+	store Store
 }
 
-func NewAuth(cfg config.Config) *Auth {
-	return &Auth{cfg: cfg}
+func NewAuth(cfg config.Config, store Store) (*Auth, error) {
+	keys, err := newKeySet(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Auth{cfg: cfg, keys: keys, store: store}, nil
 }
 
 type claims struct {
 	jwt.RegisteredClaims
-	UserID int
+	UserID    int
+	SessionID string `json:"sid"`
+	Role      string `json:"role"`
+	Purpose   string `json:"purpose,omitempty"`
 }
 
+const (
+	challengePurpose = "2fa"
+	// ChallengeTTL is how long a login challenge can be completed for.
+	ChallengeTTL    = 5 * time.Minute
+	challengeIDSize = 16
+)
+
 func (auth *Auth) Authentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(AuthCookieName)
+		method := AuthMethodBearer
+		token, ok := bearerToken(r)
+		if !ok {
+			cookie, err := r.Cookie(AuthCookieName)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			token = cookie.Value
+			method = AuthMethodCookie
+		}
+
+		if method == AuthMethodBearer && strings.HasPrefix(token, APIKeyPrefix) {
+			auth.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
+		claims, err := auth.parseToken(token)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		claims, err := auth.parseToken(cookie.Value)
+		active, err := auth.store.IsSessionActive(claims.SessionID)
 		if err != nil {
+			logger.Log.Error("failed to check session", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !active {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+		ctx = context.WithValue(ctx, RoleKey, claims.Role)
+		ctx = context.WithValue(ctx, AuthMethodKey, method)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func (auth *Auth) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	userID, scopes, err := auth.store.AuthenticateAPIKey(secret.Hash(key))
+	if errors.Is(err, errs.ErrAPIKeyNotFound) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("failed to check api key", zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey, userID)
+	ctx = context.WithValue(ctx, AuthMethodKey, AuthMethodAPIKey)
+	ctx = context.WithValue(ctx, ScopesKey, scopes)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
 func (auth *Auth) parseToken(tokenString string) (*claims, error) {
+	claims, err := auth.parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.SessionID == "" || claims.Purpose != "" {
+		return nil, fmt.Errorf("not an access token")
+	}
+
+	return claims, nil
+}
+
+func (auth *Auth) parseClaims(tokenString string) (*claims, error) {
 	claims := &claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if t.Method != jwt.SigningMethodHS256 {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Method.Alg())
-			}
-			return []byte(auth.cfg.SecretKey), nil
-		})
+	token, err := jwt.ParseWithClaims(tokenString, claims, auth.keys.verificationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -63,11 +180,112 @@ func (auth *Auth) parseToken(tokenString string) (*claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("token without expiration")
+	}
+
 	return claims, nil
 }
 
-func (auth *Auth) CreateToken(userID int) (string, error) {
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims{UserID: userID}).SignedString([]byte(auth.cfg.SecretKey))
+// CreateToken issues a short-lived access token bound to the session.
+func (auth *Auth) CreateToken(userID int, sessionID string, role string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(auth.cfg.AccessTokenTTL)
+	tokenClaims := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		UserID:    userID,
+		SessionID: sessionID,
+		Role:      role,
+	}
+	token, err := auth.keys.sign(tokenClaims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// CreateChallengeToken issues a short-lived token proving that the user has passed the password
+// check. It can only be exchanged for a session after the second factor is verified.
+func (auth *Auth) CreateChallengeToken(userID int) (string, time.Time, error) {
+	challengeID, err := secret.New(challengeIDSize)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ChallengeTTL)
+	tokenClaims := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        challengeID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		UserID:  userID,
+		Purpose: challengePurpose,
+	}
+	token, err := auth.keys.sign(tokenClaims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseChallengeToken returns the user ID and the challenge ID of a valid challenge token.
+// The token is single use: callers must consume the challenge ID before completing the login.
+func (auth *Auth) ParseChallengeToken(tokenString string) (int, string, error) {
+	claims, err := auth.parseClaims(tokenString)
+	if err != nil {
+		return 0, "", err
+	}
+	if claims.Purpose != challengePurpose || claims.ID == "" {
+		return 0, "", fmt.Errorf("not a challenge token")
+	}
+	return claims.UserID, claims.ID, nil
+}
+
+// JWKS returns the public keys tokens can be verified with. HMAC keys are never published.
+func (auth *Auth) JWKS() model.JWKS {
+	return auth.keys.jwks
+}
+
+func (auth *Auth) SetAuthCookies(w http.ResponseWriter, tokens model.Tokens) {
+	http.SetCookie(w, auth.cookie(AuthCookieName, tokens.AccessToken, int(time.Until(tokens.ExpiresAt).Seconds())))
+	http.SetCookie(w, auth.cookie(RefreshCookieName, tokens.RefreshToken, int(auth.cfg.RefreshTokenTTL.Seconds())))
+	if auth.cfg.CSRFDoubleSubmit {
+		http.SetCookie(w, auth.csrfCookie(int(auth.cfg.RefreshTokenTTL.Seconds())))
+	}
+}
+
+func (auth *Auth) ClearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, auth.cookie(AuthCookieName, "", -1))
+	http.SetCookie(w, auth.cookie(RefreshCookieName, "", -1))
+	if auth.cfg.CSRFDoubleSubmit {
+		http.SetCookie(w, auth.csrfCookie(-1))
+	}
+}
+
+func (auth *Auth) cookie(name string, value string, maxAge int) *http.Cookie {
+	sameSite := http.SameSiteStrictMode
+	switch auth.cfg.CookieSameSite {
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     auth.cfg.CookiePath,
+		Domain:   auth.cfg.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   auth.cfg.CookieSecure,
+		HttpOnly: auth.cfg.CookieHTTPOnly,
+		SameSite: sameSite,
+	}
 }
 
 func (auth *Auth) GetUserID(ctx context.Context) (int, error) {
@@ -78,3 +296,12 @@ func (auth *Auth) GetUserID(ctx context.Context) (int, error) {
 	}
 	return id, nil
 }
+
+func (auth *Auth) GetSessionID(ctx context.Context) (string, error) {
+	val := ctx.Value(SessionIDKey)
+	id, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("unable to get session id")
+	}
+	return id, nil
+}
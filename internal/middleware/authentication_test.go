@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type activeSessions struct{}
+
+func (activeSessions) IsSessionActive(string) (bool, error) {
+	return true, nil
+}
+
+func (activeSessions) AuthenticateAPIKey(string) (int, []string, error) {
+	return 0, nil, errs.ErrAPIKeyNotFound
+}
+
+func (activeSessions) GetPartnerSecret(string) (int, string, error) {
+	return 0, "", errs.ErrPartnerNotFound
+}
+
+func (activeSessions) UsePartnerNonce(int, string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := "old-secret-key-0123456789abcdefgh"
+	newKey := "new-secret-key-0123456789abcdefgh"
+
+	oldAuth, err := NewAuth(config.Config{SecretKey: oldKey, AccessTokenTTL: time.Minute}, activeSessions{})
+	require.NoError(t, err)
+	token, _, err := oldAuth.CreateToken(1, "session", "user")
+	require.NoError(t, err)
+
+	t.Run("old key accepted for verification", func(t *testing.T) {
+		cfg := config.Config{SecretKey: newKey, VerifyKeys: keyID(oldKey) + ":" + oldKey, AccessTokenTTL: time.Minute}
+		auth, err := NewAuth(cfg, activeSessions{})
+		require.NoError(t, err)
+
+		claims, err := auth.parseToken(token)
+		require.NoError(t, err)
+		require.Equal(t, 1, claims.UserID)
+	})
+
+	t.Run("retired key rejected", func(t *testing.T) {
+		auth, err := NewAuth(config.Config{SecretKey: newKey, AccessTokenTTL: time.Minute}, activeSessions{})
+		require.NoError(t, err)
+
+		_, err = auth.parseToken(token)
+		require.Error(t, err)
+	})
+
+	t.Run("key id mismatch rejected", func(t *testing.T) {
+		cfg := config.Config{SecretKey: newKey, VerifyKeys: keyID(oldKey) + ":" + newKey, AccessTokenTTL: time.Minute}
+		auth, err := NewAuth(cfg, activeSessions{})
+		require.NoError(t, err)
+
+		_, err = auth.parseToken(token)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid verify keys", func(t *testing.T) {
+		_, err := NewAuth(config.Config{SecretKey: newKey, VerifyKeys: "no-secret"}, activeSessions{})
+		require.Error(t, err)
+	})
+}
+
+func TestKeyID(t *testing.T) {
+	key := "secret-key-0123456789abcdefghijkl"
+	kid := keyID(key)
+	require.Len(t, kid, 16)
+	require.Equal(t, kid, keyID(key), "stable across restarts")
+	require.NotEqual(t, kid, keyID(key+"!"))
+	sum := sha256.Sum256([]byte(key))
+	require.NotEqual(t, hex.EncodeToString(sum[:8]), kid, "not a bare hash of the secret")
+
+	auth, err := NewAuth(config.Config{SecretKey: key, AccessTokenTTL: time.Minute}, activeSessions{})
+	require.NoError(t, err)
+	token, _, err := auth.CreateToken(1, "session", "user")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &claims{})
+	require.NoError(t, err)
+	require.Equal(t, kid, parsed.Header["kid"])
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	edFile := writePEM(t, dir, "ed25519.pem", "PRIVATE KEY", edKey)
+	rsaFile := writePEM(t, dir, "rsa.pem", "PRIVATE KEY", rsaKey)
+	rsaPublicFile := writePEM(t, dir, "rsa.pub.pem", "PUBLIC KEY", rsaKey.Public())
+
+	for name, file := range map[string]string{"EdDSA": edFile, "RS256": rsaFile} {
+		t.Run(name, func(t *testing.T) {
+			auth, err := NewAuth(config.Config{SigningKeyFile: file, AccessTokenTTL: time.Minute}, activeSessions{})
+			require.NoError(t, err)
+
+			token, _, err := auth.CreateToken(1, "session", "user")
+			require.NoError(t, err)
+			claims, err := auth.parseToken(token)
+			require.NoError(t, err)
+			require.Equal(t, 1, claims.UserID)
+
+			jwks := auth.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			require.Equal(t, name, jwks.Keys[0].Algorithm)
+		})
+	}
+
+	t.Run("old public key accepted for verification", func(t *testing.T) {
+		oldAuth, err := NewAuth(config.Config{SigningKeyFile: rsaFile, AccessTokenTTL: time.Minute}, activeSessions{})
+		require.NoError(t, err)
+		token, _, err := oldAuth.CreateToken(1, "session", "user")
+		require.NoError(t, err)
+
+		cfg := config.Config{SigningKeyFile: edFile, VerifyKeyFiles: rsaPublicFile, AccessTokenTTL: time.Minute}
+		auth, err := NewAuth(cfg, activeSessions{})
+		require.NoError(t, err)
+		_, err = auth.parseToken(token)
+		require.NoError(t, err)
+		require.Len(t, auth.JWKS().Keys, 2)
+	})
+
+	t.Run("HMAC secret not published", func(t *testing.T) {
+		cfg := config.Config{SigningKeyFile: edFile, SecretKey: "old-secret-key-0123456789abcdefgh"}
+		auth, err := NewAuth(cfg, activeSessions{})
+		require.NoError(t, err)
+		require.Len(t, auth.JWKS().Keys, 1)
+		require.Equal(t, "OKP", auth.JWKS().Keys[0].KeyType)
+	})
+}
+
+func writePEM(t *testing.T, dir string, name string, blockType string, key any) string {
+	var der []byte
+	var err error
+	if blockType == "PUBLIC KEY" {
+		der, err = x509.MarshalPKIXPublicKey(key)
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestChallengeToken(t *testing.T) {
+	auth, err := NewAuth(config.Config{DevMode: true, AccessTokenTTL: time.Minute}, activeSessions{})
+	require.NoError(t, err)
+
+	challenge, _, err := auth.CreateChallengeToken(1)
+	require.NoError(t, err)
+	userID, challengeID, err := auth.ParseChallengeToken(challenge)
+	require.NoError(t, err)
+	require.Equal(t, 1, userID)
+	require.NotEmpty(t, challengeID)
+	other, _, err := auth.CreateChallengeToken(1)
+	require.NoError(t, err)
+	_, otherID, err := auth.ParseChallengeToken(other)
+	require.NoError(t, err)
+	require.NotEqual(t, challengeID, otherID, "every challenge has its own ID")
+
+	_, err = auth.parseToken(challenge)
+	require.Error(t, err, "challenge token is not an access token")
+
+	access, _, err := auth.CreateToken(1, "session", "user")
+	require.NoError(t, err)
+	_, _, err = auth.ParseChallengeToken(access)
+	require.Error(t, err, "access token is not a challenge token")
+}
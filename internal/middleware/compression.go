@@ -7,15 +7,20 @@ import (
 	"strings"
 )
 
+// gzipETagSuffix distinguishes entity tags of compressed representations,
+// since a strong ETag must change along with the response bytes.
+const gzipETagSuffix = "-gzip"
+
 var compressingEnableEncodings = []string{
 	"application/json",
 	"text/html",
 }
 
 type compressedWriter struct {
-	httpWriter http.ResponseWriter
-	writer     io.Writer
-	status     int
+	httpWriter        http.ResponseWriter
+	writer            io.Writer
+	status            int
+	gzipETagRequested bool
 }
 
 func newCompressedWriter(httpWriter http.ResponseWriter) *compressedWriter {
@@ -39,6 +44,16 @@ func (c *compressedWriter) Write(p []byte) (int, error) {
 	return c.writer.Write(p)
 }
 
+func (c *compressedWriter) Flush() {
+	c.initWriter()
+	if gz, ok := c.writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	if flusher, ok := c.httpWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (c *compressedWriter) initWriter() {
 	if c.writer != nil {
 		return
@@ -58,11 +73,22 @@ func (c *compressedWriter) initWriter() {
 	}
 
 	c.Header().Set("Content-Encoding", "gzip")
+	c.markGzipETag()
 	c.writer = gzip.NewWriter(c.httpWriter)
 }
 
+func (c *compressedWriter) markGzipETag() {
+	etag := c.Header().Get("ETag")
+	if strings.HasSuffix(etag, `"`) {
+		c.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+gzipETagSuffix+`"`)
+	}
+}
+
 func (c *compressedWriter) Close() error {
 	if c.writer == nil {
+		if c.status == http.StatusNotModified && c.gzipETagRequested {
+			c.markGzipETag()
+		}
 		c.httpWriter.WriteHeader(c.status)
 		return nil
 	}
@@ -87,6 +113,7 @@ func Compression(next http.Handler) http.Handler {
 			r.Header.Del("Content-Encoding")
 		}
 
+		w.Header().Add("Vary", "Accept-Encoding")
 		acceptEncodings := strings.Split(r.Header.Get("Accept-Encoding"), ",")
 		supportsGzip := false
 		for _, acceptEncoding := range acceptEncodings {
@@ -99,6 +126,11 @@ func Compression(next http.Handler) http.Handler {
 		}
 
 		cw := newCompressedWriter(w)
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+			stripped := strings.ReplaceAll(ifNoneMatch, gzipETagSuffix+`"`, `"`)
+			cw.gzipETagRequested = stripped != ifNoneMatch
+			r.Header.Set("If-None-Match", stripped)
+		}
 		defer cw.Close()
 		next.ServeHTTP(cw, r)
 	})
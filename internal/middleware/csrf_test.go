@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCSRF(t *testing.T) {
+	cfg := config.Config{DevMode: true, CSRFTrustedOrigins: "https://shop.example.com"}
+	auth, err := NewAuth(cfg, activeSessions{})
+	require.NoError(t, err)
+	handler := auth.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name    string
+		method  string
+		auth    string
+		headers map[string]string
+		status  int
+	}{
+		{"same origin fetch", "POST", AuthMethodCookie, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"cross site fetch", "POST", AuthMethodCookie, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"trusted cross site fetch", "POST", AuthMethodCookie, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://shop.example.com"}, http.StatusOK},
+		{"same origin", "POST", AuthMethodCookie, map[string]string{"Origin": "http://gophermart.test"}, http.StatusOK},
+		{"foreign origin", "POST", AuthMethodCookie, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"null origin", "POST", AuthMethodCookie, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"non-browser client", "POST", AuthMethodCookie, nil, http.StatusOK},
+		{"safe method", "GET", AuthMethodCookie, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"bearer token", "POST", AuthMethodBearer, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"api key", "DELETE", AuthMethodAPIKey, map[string]string{"Origin": "https://evil.example"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://gophermart.test/api/user/balance/withdraw", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), AuthMethodKey, tt.auth))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	auth, err := NewAuth(config.Config{DevMode: true, CSRFDoubleSubmit: true, RefreshTokenTTL: time.Hour}, activeSessions{})
+	require.NoError(t, err)
+	handler := auth.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	auth.SetAuthCookies(rec, model.Tokens{})
+	var token *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == CSRFCookieName {
+			token = c
+		}
+	}
+	require.NotNil(t, token)
+	require.False(t, token.HttpOnly)
+
+	for header, status := range map[string]int{token.Value: http.StatusOK, "forged": http.StatusForbidden, "": http.StatusForbidden} {
+		req := httptest.NewRequest("POST", "http://gophermart.test/api/user/orders", nil)
+		req.AddCookie(token)
+		req.Header.Set(CSRFHeader, header)
+		req = req.WithContext(context.WithValue(req.Context(), AuthMethodKey, AuthMethodCookie))
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, status, rec.Code, header)
+	}
+}
+
+func TestRefreshCSRF(t *testing.T) {
+	auth, err := NewAuth(config.Config{DevMode: true}, activeSessions{})
+	require.NoError(t, err)
+	handler := auth.RefreshCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name    string
+		cookie  bool
+		headers map[string]string
+		status  int
+	}{
+		{"same origin fetch", true, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"cross site fetch", true, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"foreign origin", true, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"non-browser client", true, nil, http.StatusOK},
+		{"token in body", false, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://gophermart.test/api/user/refresh", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: RefreshCookieName, Value: "refresh"})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
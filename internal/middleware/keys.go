@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/model"
+	"math/big"
+	"os"
+	"strings"
+)
+
+const minRSAKeyBits = 2048
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// keySet holds the key new tokens are signed with and the keys tokens are verified with, by key ID.
+type keySet struct {
+	signingKeyID  string
+	signingMethod jwt.SigningMethod
+	signingKey    interface{}
+	keys          map[string]verificationKey
+	jwks          model.JWKS
+}
+
+// newKeySet signs with the private key from cfg.SigningKeyFile if set, and with cfg.SecretKey otherwise.
+// In the first case the secret key, if any, is only used to verify tokens issued before the switch.
+func newKeySet(cfg config.Config) (*keySet, error) {
+	ks := &keySet{keys: make(map[string]verificationKey), jwks: model.JWKS{Keys: make([]model.JWK, 0)}}
+
+	if cfg.SigningKeyFile != "" {
+		method, key, public, err := loadPrivateKey(cfg.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		kid := cfg.SecretKeyID
+		if kid == "" {
+			kid, err = publicKeyID(public)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ks.signingKeyID, ks.signingMethod, ks.signingKey = kid, method, key
+		if err = ks.addPublicKey(kid, method, public); err != nil {
+			return nil, err
+		}
+		if cfg.SecretKey != "" {
+			if err = ks.add(keyID(cfg.SecretKey), jwt.SigningMethodHS256, []byte(cfg.SecretKey)); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		kid := cfg.SecretKeyID
+		if kid == "" {
+			kid = keyID(cfg.SecretKey)
+		}
+		ks.signingKeyID, ks.signingMethod, ks.signingKey = kid, jwt.SigningMethodHS256, []byte(cfg.SecretKey)
+		if err := ks.add(kid, jwt.SigningMethodHS256, []byte(cfg.SecretKey)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pair := range splitList(cfg.VerifyKeys) {
+		kid, secret, found := strings.Cut(pair, ":")
+		if !found || kid == "" || secret == "" {
+			return nil, fmt.Errorf("verify keys must be comma separated kid:secret pairs")
+		}
+		if err := ks.add(kid, jwt.SigningMethodHS256, []byte(secret)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, path := range splitList(cfg.VerifyKeyFiles) {
+		method, public, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		kid, err := publicKeyID(public)
+		if err != nil {
+			return nil, err
+		}
+		if err = ks.addPublicKey(kid, method, public); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+func (ks *keySet) add(kid string, method jwt.SigningMethod, key interface{}) error {
+	if _, exists := ks.keys[kid]; exists {
+		return fmt.Errorf("duplicate key id %q", kid)
+	}
+	ks.keys[kid] = verificationKey{method: method, key: key}
+	return nil
+}
+
+func (ks *keySet) addPublicKey(kid string, method jwt.SigningMethod, public crypto.PublicKey) error {
+	if err := ks.add(kid, method, public); err != nil {
+		return err
+	}
+	ks.jwks.Keys = append(ks.jwks.Keys, newJWK(kid, method, public))
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// keyIDLabel is the message HMAC key IDs are computed over.
+const keyIDLabel = "gophermart jwt key id"
+
+// keyID derives a stable key ID from the key so that rotating keys doesn't require configuring IDs.
+// The ID is an HMAC keyed with the secret rather than a plain hash of it, so that it goes out in
+// token headers without giving away a fingerprint of the secret any cheaper to test guesses
+// against than the token signature itself.
+func keyID(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(keyIDLabel))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func publicKeyID(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func (ks *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signingMethod, claims)
+	token.Header["kid"] = ks.signingKeyID
+	return token.SignedString(ks.signingKey)
+}
+
+func (ks *keySet) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token without key id")
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	// The algorithm is bound to the key, so a public key can never be used as an HMAC secret.
+	if t.Method != key.method {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Method.Alg())
+	}
+	return key.key, nil
+}
+
+func loadPrivateKey(path string) (jwt.SigningMethod, crypto.Signer, crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, nil, nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, nil, nil, fmt.Errorf("%s: RSA key must be at least %d bits", path, minRSAKeyBits)
+		}
+		return jwt.SigningMethodRS256, key, key.Public(), nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, key, key.Public(), nil
+	default:
+		return nil, nil, nil, fmt.Errorf("%s: unsupported key type %T, RSA or Ed25519 expected", path, key)
+	}
+}
+
+func loadPublicKey(path string) (jwt.SigningMethod, crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, key, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, key, nil
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported key type %T, RSA or Ed25519 expected", path, key)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+func newJWK(kid string, method jwt.SigningMethod, public crypto.PublicKey) model.JWK {
+	jwk := model.JWK{KeyID: kid, Use: "sig", Algorithm: method.Alg()}
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	}
+	return jwk
+}
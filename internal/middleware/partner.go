@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"go.uber.org/zap"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	PartnerKeyHeader       = "X-Partner-Key"
+	PartnerTimestampHeader = "X-Partner-Timestamp"
+	PartnerNonceHeader     = "X-Partner-Nonce"
+	PartnerSignatureHeader = "X-Partner-Signature"
+
+	maxPartnerClockSkew = 5 * time.Minute
+	maxPartnerBodySize  = 1 << 20
+	minPartnerNonce     = 16
+	maxPartnerNonce     = 128
+)
+
+type PartnerChecker interface {
+	GetPartnerSecret(keyID string) (int, string, error)
+	// UsePartnerNonce remembers the nonce for the ttl and reports whether it wasn't used before.
+	UsePartnerNonce(partnerID int, nonce string, ttl time.Duration) (bool, error)
+}
+
+// PartnerSignature authenticates server-to-server partner requests signed with the partner's secret.
+// See SignPartnerRequest for the signature format. Every request carries a unique nonce, so that
+// a captured request can't be replayed while its timestamp is still accepted.
+func (auth *Auth) PartnerSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, err := strconv.ParseInt(r.Header.Get(PartnerTimestampHeader), 10, 64)
+		if err != nil || math.Abs(time.Since(time.Unix(timestamp, 0)).Seconds()) > maxPartnerClockSkew.Seconds() {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		nonce := r.Header.Get(PartnerNonceHeader)
+		if len(nonce) < minPartnerNonce || len(nonce) > maxPartnerNonce {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		signature, err := hex.DecodeString(r.Header.Get(PartnerSignatureHeader))
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		partnerID, secret, err := auth.store.GetPartnerSecret(r.Header.Get(PartnerKeyHeader))
+		if errors.Is(err, errs.ErrPartnerNotFound) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logger.Log.Error("failed to check partner", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPartnerBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := partnerMAC(secret, timestamp, nonce, r.Method, r.URL.RequestURI(), body)
+		if !hmac.Equal(signature, expected) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// the nonce must outlive the timestamp, which is accepted within the skew both ways
+		fresh, err := auth.store.UsePartnerNonce(partnerID, nonce, 2*maxPartnerClockSkew)
+		if err != nil {
+			logger.Log.Error("failed to check partner nonce", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !fresh {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), PartnerIDKey, partnerID)))
+	})
+}
+
+// SignPartnerRequest returns the value of the signature header: hex-encoded HMAC-SHA256 of
+// "<timestamp>\n<nonce>\n<method>\n<request URI>\n<body>" keyed with the partner secret.
+// The nonce is a random string of 16 to 128 bytes, unique for every request.
+func SignPartnerRequest(secret string, timestamp int64, nonce string, method string, requestURI string, body []byte) string {
+	return hex.EncodeToString(partnerMAC(secret, timestamp, nonce, method, requestURI, body))
+}
+
+func partnerMAC(secret string, timestamp int64, nonce string, method string, requestURI string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s\n", timestamp, nonce, method, requestURI)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func GetPartnerID(ctx context.Context) (int, error) {
+	id, ok := ctx.Value(PartnerIDKey).(int)
+	if !ok {
+		return 0, fmt.Errorf("unable to get partner id")
+	}
+	return id, nil
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type partnerStore struct {
+	activeSessions
+	nonces map[string]time.Duration
+}
+
+func (partnerStore) GetPartnerSecret(keyID string) (int, string, error) {
+	if keyID != "pk_test" {
+		return 0, "", errs.ErrPartnerNotFound
+	}
+	return 7, "partner-secret", nil
+}
+
+func (s partnerStore) UsePartnerNonce(_ int, nonce string, ttl time.Duration) (bool, error) {
+	if _, ok := s.nonces[nonce]; ok {
+		return false, nil
+	}
+	s.nonces[nonce] = ttl
+	return true, nil
+}
+
+func TestPartnerSignature(t *testing.T) {
+	store := partnerStore{nonces: make(map[string]time.Duration)}
+	auth, err := NewAuth(config.Config{DevMode: true}, store)
+	require.NoError(t, err)
+
+	handler := auth.PartnerSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partnerID, err := GetPartnerID(r.Context())
+		require.NoError(t, err)
+		require.Equal(t, 7, partnerID)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Write(body)
+	}))
+
+	body := []byte(`{"number":"12345678903","login":"user"}`)
+	var sequence int
+	newNonce := func() string {
+		sequence++
+		return fmt.Sprintf("nonce-%016d", sequence)
+	}
+	signedRequest := func(keyID string, secret string, timestamp time.Time, nonce string, signedNonce string, signedBody []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/partner/orders?source=pos", bytes.NewReader(body))
+		req.Header.Set(PartnerKeyHeader, keyID)
+		req.Header.Set(PartnerTimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+		req.Header.Set(PartnerNonceHeader, nonce)
+		req.Header.Set(PartnerSignatureHeader,
+			SignPartnerRequest(secret, timestamp.Unix(), signedNonce, http.MethodPost, "/api/partner/orders?source=pos", signedBody))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	request := func(keyID string, secret string, timestamp time.Time, signedBody []byte) *httptest.ResponseRecorder {
+		nonce := newNonce()
+		return signedRequest(keyID, secret, timestamp, nonce, nonce, signedBody)
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		rec := request("pk_test", "partner-secret", time.Now(), body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, body, rec.Body.Bytes())
+		require.Equal(t, 2*maxPartnerClockSkew, store.nonces[fmt.Sprintf("nonce-%016d", sequence)])
+	})
+
+	t.Run("replayed nonce", func(t *testing.T) {
+		nonce := newNonce()
+		rec := signedRequest("pk_test", "partner-secret", time.Now(), nonce, nonce, body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		rec = signedRequest("pk_test", "partner-secret", time.Now(), nonce, nonce, body)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("unsigned nonce", func(t *testing.T) {
+		rec := signedRequest("pk_test", "partner-secret", time.Now(), newNonce(), newNonce(), body)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("missing nonce", func(t *testing.T) {
+		for _, nonce := range []string{"", "short", strings.Repeat("n", maxPartnerNonce+1)} {
+			rec := signedRequest("pk_test", "partner-secret", time.Now(), nonce, nonce, body)
+			require.Equal(t, http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		rec := request("pk_test", "partner-secret", time.Now(), []byte(`{}`))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		rec := request("pk_test", "other-secret", time.Now(), body)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("unknown partner", func(t *testing.T) {
+		rec := request("pk_other", "partner-secret", time.Now(), body)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("stale timestamp", func(t *testing.T) {
+		rec := request("pk_test", "partner-secret", time.Now().Add(-10*time.Minute), body)
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
@@ -1,26 +1,45 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type UserCredentials struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
 
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
 type Order struct {
-	Order      int       `json:"number,string"`
+	Order      string    `json:"number"`
 	Status     string    `json:"status"`
 	Accrual    float64   `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
 
 type Withdraw struct {
-	Order int     `json:"order,string"`
-	Sum   float64 `json:"sum"`
+	Order    string  `json:"order"`
+	Sum      float64 `json:"sum"`
+	TOTPCode string  `json:"totp_code,omitempty"`
 }
 
 type Withdrawal struct {
-	Order       int       `json:"order,string"`
+	Order       string    `json:"order"`
 	Sum         float64   `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
@@ -31,7 +50,241 @@ type Balance struct {
 }
 
 type AccrualResp struct {
-	Order   int     `json:"order,string"`
+	Order   string  `json:"order"`
 	Status  string  `json:"status"`
 	Accrual float64 `json:"accrual,omitempty"`
 }
+
+type OrderBatchResult struct {
+	Order  string `json:"number"`
+	Result string `json:"result"`
+	Code   int    `json:"code"`
+}
+
+type OrderEvent struct {
+	ID        int64     `json:"-"`
+	UserID    int       `json:"-"`
+	Order     string    `json:"number"`
+	Status    string    `json:"status"`
+	Accrual   float64   `json:"accrual,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookEvent is an event with its delivery payload, to be enqueued for the webhooks subscribed to it.
+type WebhookEvent struct {
+	Event   string
+	Payload []byte
+}
+
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+	URL           string          `json:"-"`
+	Secret        string          `json:"-"`
+}
+
+type Tokens struct {
+	TokenType    string    `json:"token_type"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type AccountDeletion struct {
+	Password string `json:"password"`
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// AnonymizedLoginPrefix starts the login of a deleted account kept for accounting, followed
+// by the user ID. Logins with it are never accepted, so no one can register or sign in as one.
+const AnonymizedLoginPrefix = "deleted user "
+
+type AdminUser struct {
+	ID        int        `json:"id"`
+	Login     string     `json:"login"`
+	Role      string     `json:"role"`
+	BlockedAt *time.Time `json:"blocked_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type AdminOrder struct {
+	Order
+	UserID    int `json:"user_id"`
+	PartnerID int `json:"partner_id,omitempty"`
+}
+
+type AdminOrderFilter struct {
+	Number string `json:"number,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+const (
+	AdjustmentPending  = "PENDING"
+	AdjustmentApplied  = "APPLIED"
+	AdjustmentRejected = "REJECTED"
+)
+
+var AdjustmentReasons = []string{"compensation", "goodwill", "fraud", "correction"}
+
+type AdjustmentRequest struct {
+	Amount  float64 `json:"amount"`
+	Reason  string  `json:"reason"`
+	Comment string  `json:"comment"`
+}
+
+// BalanceAdjustment is a manual change of a user's balance. Fields tagged omitempty
+// are only shown to admins.
+type BalanceAdjustment struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id,omitempty"`
+	Amount     float64    `json:"amount"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment,omitempty"`
+	Status     string     `json:"status"`
+	CreatedBy  int        `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedBy int        `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+const (
+	ScopeOrdersRead       = "orders:read"
+	ScopeOrdersWrite      = "orders:write"
+	ScopeBalanceRead      = "balance:read"
+	ScopeWithdrawalsRead  = "withdrawals:read"
+	ScopeWithdrawalsWrite = "withdrawals:write"
+)
+
+var Scopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeWithdrawalsRead, ScopeWithdrawalsWrite}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// APIKey describes a key of a user. The key itself is only returned on creation.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type PartnerRequest struct {
+	Name string `json:"name"`
+}
+
+// Partner is a merchant submitting orders on behalf of users. The secret is only returned on creation.
+type Partner struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	KeyID     string    `json:"key_id"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PartnerOrder identifies the customer either by login or by the partner's external ID.
+type PartnerOrder struct {
+	Number     string `json:"number"`
+	Login      string `json:"login,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+type PartnerCustomer struct {
+	ExternalID string `json:"external_id"`
+	Login      string `json:"login"`
+}
+
+// LoginResult holds either the tokens or, if the user has two-factor authentication enabled,
+// the challenge to complete at /api/user/login/2fa.
+type LoginResult struct {
+	Tokens    *Tokens
+	Challenge *Challenge
+}
+
+type Challenge struct {
+	ChallengeToken string    `json:"challenge_token"`
+	Method         string    `json:"method"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+type ChallengeResponse struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sender delivers password reset tokens to users.
+type Sender interface {
+	SendPasswordReset(login string, token string) error
+}
+
+func NewSender(cfg config.Config) (Sender, error) {
+	switch cfg.ResetDelivery {
+	case "log":
+		return LogSender{}, nil
+	case "file":
+		if cfg.ResetDeliveryFile == "" {
+			return nil, fmt.Errorf("password reset delivery file is not set")
+		}
+		return &FileSender{path: cfg.ResetDeliveryFile}, nil
+	default:
+		return nil, fmt.Errorf("unknown password reset delivery: %q", cfg.ResetDelivery)
+	}
+}
+
+// LogSender writes reset tokens to the service log. It is meant for local development only.
+type LogSender struct{}
+
+func (LogSender) SendPasswordReset(login string, token string) error {
+	logger.Log.Info("password reset requested", zap.String("login", login), zap.String("token", token))
+	return nil
+}
+
+// FileSender appends reset tokens to a file as JSON lines.
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+type resetMessage struct {
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (f *FileSender) SendPasswordReset(login string, token string) error {
+	line, err := json.Marshal(resetMessage{Login: login, Token: token, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,85 @@
+package password
+
+import (
+	"context"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/config"
+)
+
+// Hasher is a password hashing algorithm producing self-describing hashes,
+// i.e. hashes that carry the algorithm and its parameters.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, hash string) (bool, error)
+	// Recognizes reports whether the hash has been produced by this algorithm.
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether the hash has been produced with other parameters.
+	NeedsRehash(hash string) bool
+}
+
+// Manager hashes new passwords with the configured algorithm and verifies hashes
+// of any known algorithm. All the work is done on a bounded pool.
+type Manager struct {
+	current Hasher
+	known   []Hasher
+	pool    *Pool
+}
+
+func NewManager(cfg config.Config, pool *Pool) (*Manager, error) {
+	bcryptHasher := NewBcrypt(cfg.BcryptCost)
+	argon2Hasher := NewArgon2id(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Iterations), uint8(cfg.Argon2Parallelism))
+
+	var current Hasher
+	switch cfg.PasswordHashAlgorithm {
+	case "bcrypt":
+		current = bcryptHasher
+	case "argon2id":
+		current = argon2Hasher
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %q", cfg.PasswordHashAlgorithm)
+	}
+
+	return &Manager{
+		current: current,
+		known:   []Hasher{argon2Hasher, bcryptHasher},
+		pool:    pool,
+	}, nil
+}
+
+func (m *Manager) Hash(ctx context.Context, password string) (string, error) {
+	var hash string
+	var err error
+	if poolErr := m.pool.Do(ctx, func() {
+		hash, err = m.current.Hash(password)
+	}); poolErr != nil {
+		return "", poolErr
+	}
+	return hash, err
+}
+
+// Verify reports whether the password matches the hash and whether the hash
+// should be replaced because it was produced by another algorithm or with other parameters.
+func (m *Manager) Verify(ctx context.Context, password string, hash string) (bool, bool, error) {
+	var hasher Hasher
+	for _, known := range m.known {
+		if known.Recognizes(hash) {
+			hasher = known
+			break
+		}
+	}
+	if hasher == nil {
+		return false, false, fmt.Errorf("unknown password hash format")
+	}
+
+	var ok bool
+	var err error
+	if poolErr := m.pool.Do(ctx, func() {
+		ok, err = hasher.Verify(password, hash)
+	}); poolErr != nil {
+		return false, false, poolErr
+	}
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, hasher != m.current || m.current.NeedsRehash(hash), nil
+}
@@ -0,0 +1,64 @@
+package password
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func newTestManager(t *testing.T, algorithm string, bcryptCost int, argon2Iterations uint) *Manager {
+	m, err := NewManager(config.Config{
+		PasswordHashAlgorithm: algorithm,
+		BcryptCost:            bcryptCost,
+		Argon2Memory:          64,
+		Argon2Iterations:      argon2Iterations,
+		Argon2Parallelism:     1,
+	}, NewPool(1, 1))
+	require.NoError(t, err)
+	return m
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	bcryptManager := newTestManager(t, "bcrypt", 4, 1)
+	argon2Manager := newTestManager(t, "argon2id", 4, 1)
+
+	bcryptHash, err := bcryptManager.Hash(ctx, "secret")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(bcryptHash, "$2a$04$"))
+
+	argon2Hash, err := argon2Manager.Hash(ctx, "secret")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=64,t=1,p=1$"))
+
+	tests := []struct {
+		name        string
+		manager     *Manager
+		hash        string
+		password    string
+		ok          bool
+		needsRehash bool
+	}{
+		{"bcrypt current", bcryptManager, bcryptHash, "secret", true, false},
+		{"bcrypt mismatch", bcryptManager, bcryptHash, "wrong", false, false},
+		{"bcrypt cost changed", newTestManager(t, "bcrypt", 5, 1), bcryptHash, "secret", true, true},
+		{"bcrypt upgraded to argon2id", argon2Manager, bcryptHash, "secret", true, true},
+		{"argon2id current", argon2Manager, argon2Hash, "secret", true, false},
+		{"argon2id mismatch", argon2Manager, argon2Hash, "wrong", false, false},
+		{"argon2id params changed", newTestManager(t, "argon2id", 4, 2), argon2Hash, "secret", true, true},
+		{"argon2id downgraded to bcrypt", bcryptManager, argon2Hash, "secret", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := tt.manager.Verify(ctx, tt.password, tt.hash)
+			require.NoError(t, err)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.needsRehash, needsRehash)
+		})
+	}
+
+	_, _, err = argon2Manager.Verify(ctx, "secret", "plaintext")
+	require.Error(t, err)
+}
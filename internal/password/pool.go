@@ -0,0 +1,55 @@
+package password
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"time"
+)
+
+// busyRetryAfter is suggested to clients rejected because of a full queue.
+const busyRetryAfter = time.Second
+
+// Pool runs CPU-heavy jobs on a fixed number of workers. Jobs that don't fit
+// into the queue are rejected instead of piling up.
+type Pool struct {
+	jobs chan func()
+}
+
+func NewPool(workers int, queueSize int) *Pool {
+	p := &Pool{jobs: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	for job := range p.jobs {
+		job()
+	}
+}
+
+// Do runs fn on the pool and waits for it. If the caller gives up waiting,
+// the job still runs to completion but its result is dropped.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		if ctx.Err() == nil {
+			fn()
+		}
+	}
+
+	select {
+	case p.jobs <- job:
+	default:
+		return errs.NewRetryAfterError(errs.ErrServiceBusy, busyRetryAfter)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,31 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPoolRejectsWhenQueueIsFull(t *testing.T) {
+	pool := NewPool(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go pool.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	go pool.Do(context.Background(), func() {})
+	require.Eventually(t, func() bool { return len(pool.jobs) == 1 }, time.Second, time.Millisecond)
+
+	err := pool.Do(context.Background(), func() {})
+	require.True(t, errors.Is(err, errs.ErrServiceBusy))
+
+	close(release)
+	require.Eventually(t, func() bool { return len(pool.jobs) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, pool.Do(context.Background(), func() {}))
+}
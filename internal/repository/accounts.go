@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"time"
+)
+
+func (r *Repo) GetUserCredentialsByID(userID int) (string, string, error) {
+	var login, passHash string
+	row := r.db.QueryRow("SELECT login, password FROM users WHERE id = $1 AND deleted_at IS NULL", userID)
+	err := row.Scan(&login, &passHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", errs.ErrUserCredentials
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return login, passHash, nil
+}
+
+// SetPassword replaces the password hash and revokes all the user's sessions but keepSessionID.
+func (r *Repo) SetPassword(userID int, passwordHash string, keepSessionID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("UPDATE users SET password = $2 WHERE id = $1", userID, passwordHash); err != nil {
+		return err
+	}
+	if err = revokeUserSessions(tx, userID, keepSessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func revokeUserSessions(tx *sql.Tx, userID int, keepSessionID string) error {
+	query := "UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL"
+	_, err := tx.Exec(query, userID, keepSessionID)
+	return err
+}
+
+// CreatePasswordReset stores a new reset token of the user and invalidates the previous ones.
+func (r *Repo) CreatePasswordReset(userID int, tokenHash string, ttl time.Duration) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL"
+	if _, err = tx.Exec(query, userID); err != nil {
+		return err
+	}
+	query = "INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))"
+	if _, err = tx.Exec(query, tokenHash, userID, ttl.Seconds()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repo) GetPasswordResetUser(tokenHash string) (int, error) {
+	var userID int
+	query := "SELECT user_id FROM password_resets WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()"
+	err := r.db.QueryRow(query, tokenHash).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errs.ErrInvalidResetToken
+	}
+	return userID, err
+}
+
+// ResetPassword consumes the reset token, sets the new password hash and revokes all the user's sessions.
+func (r *Repo) ResetPassword(tokenHash string, passwordHash string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `
+		UPDATE password_resets SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`
+	err = tx.QueryRow(query, tokenHash).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE users SET password = $2 WHERE id = $1", userID, passwordHash); err != nil {
+		return err
+	}
+	if err = revokeUserSessions(tx, userID, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUser removes the account. With the "anonymize" retention the user's orders and
+// withdrawals are kept for accounting under an anonymized user record, with "delete"
+// everything the user owns is removed. The record of a user who acted as an admin is
+// anonymized either way, so that the admin records stay attributed.
+func (r *Repo) DeleteUser(userID int, retention string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT true FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.ErrUserCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	queries := []string{
+		"DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE user_id = $1)",
+		"DELETE FROM sessions WHERE user_id = $1",
+		"DELETE FROM password_resets WHERE user_id = $1",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = $1)",
+		"DELETE FROM webhooks WHERE user_id = $1",
+		"DELETE FROM order_events WHERE user_id = $1",
+		"DELETE FROM api_keys WHERE user_id = $1",
+		"DELETE FROM partner_customers WHERE user_id = $1",
+		"DELETE FROM recovery_codes WHERE user_id = $1",
+	}
+	anonymize := "UPDATE users SET login = '" + model.AnonymizedLoginPrefix + "' || id, password = '', totp_secret = NULL, deleted_at = now() WHERE id = $1"
+	switch retention {
+	case "anonymize":
+		queries = append(queries, anonymize)
+	case "delete":
+		queries = append(queries,
+			"DELETE FROM order_audit WHERE user_id = $1",
+			"DELETE FROM orders WHERE user_id = $1",
+			"DELETE FROM withdrawals WHERE user_id = $1",
+			"DELETE FROM balance_adjustments WHERE user_id = $1",
+			`DELETE FROM users WHERE id = $1
+				AND NOT EXISTS (SELECT 1 FROM admin_audit WHERE admin_id = $1)
+				AND NOT EXISTS (SELECT 1 FROM balance_adjustments WHERE created_by = $1 OR reviewed_by = $1)`,
+			anonymize,
+		)
+	default:
+		return fmt.Errorf("unknown retention policy: %q", retention)
+	}
+
+	for _, query := range queries {
+		if _, err = tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+)
+
+const adjustmentColumns = "id, user_id, amount, reason, comment, status, created_by, created_at, reviewed_by, reviewed_at"
+
+func (r *Repo) CreateAdjustment(adjustment model.BalanceAdjustment) (model.BalanceAdjustment, error) {
+	query := `
+		INSERT INTO balance_adjustments (user_id, amount, reason, comment, status, created_by)
+		SELECT id, $2, $3, $4, $5, $6 FROM users WHERE id = $1 AND deleted_at IS NULL
+		RETURNING ` + adjustmentColumns
+	adjustment, err := scanAdjustment(r.db.QueryRow(query, adjustment.UserID, adjustment.Amount, adjustment.Reason,
+		adjustment.Comment, adjustment.Status, adjustment.CreatedBy))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.BalanceAdjustment{}, errs.ErrUserNotFound
+	}
+	return adjustment, err
+}
+
+// GetAdjustments returns adjustments of all users with the status, or with any status if it is empty.
+func (r *Repo) GetAdjustments(status string) ([]model.BalanceAdjustment, error) {
+	query := "SELECT " + adjustmentColumns + " FROM balance_adjustments WHERE $1 = '' OR status = $1 ORDER BY id DESC LIMIT $2"
+	rows, err := r.db.Query(query, status, maxSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := make([]model.BalanceAdjustment, 0)
+	for rows.Next() {
+		adjustment, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+// GetUserAdjustments returns the applied adjustments of the user without the admin-only details.
+func (r *Repo) GetUserAdjustments(userID int) ([]model.BalanceAdjustment, error) {
+	query := `
+		SELECT id, amount, reason, status, coalesce(reviewed_at, created_at)
+		FROM balance_adjustments WHERE user_id = $1 AND status = 'APPLIED'
+		ORDER BY id DESC`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := make([]model.BalanceAdjustment, 0)
+	for rows.Next() {
+		var adjustment model.BalanceAdjustment
+		err = rows.Scan(&adjustment.ID, &adjustment.Amount, &adjustment.Reason, &adjustment.Status, &adjustment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+// ReviewAdjustment approves or rejects a pending adjustment. The reviewer must be neither its
+// creator nor the user whose balance it adjusts.
+func (r *Repo) ReviewAdjustment(adjustmentID int, reviewerID int, approve bool) (model.BalanceAdjustment, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var createdBy, userID int
+	query := "SELECT status, created_by, user_id FROM balance_adjustments WHERE id = $1 FOR UPDATE"
+	err = tx.QueryRow(query, adjustmentID).Scan(&status, &createdBy, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentNotFound
+	}
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	if status != model.AdjustmentPending {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentReviewed
+	}
+	if createdBy == reviewerID || userID == reviewerID {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentSelfApproval
+	}
+
+	status = model.AdjustmentRejected
+	if approve {
+		status = model.AdjustmentApplied
+	}
+	query = `
+		UPDATE balance_adjustments SET status = $2, reviewed_by = $3, reviewed_at = now()
+		WHERE id = $1 RETURNING ` + adjustmentColumns
+	adjustment, err := scanAdjustment(tx.QueryRow(query, adjustmentID, status, reviewerID))
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	return adjustment, tx.Commit()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAdjustment(row rowScanner) (model.BalanceAdjustment, error) {
+	var adjustment model.BalanceAdjustment
+	var reviewedBy sql.NullInt64
+	var reviewedAt sql.NullTime
+	err := row.Scan(&adjustment.ID, &adjustment.UserID, &adjustment.Amount, &adjustment.Reason, &adjustment.Comment,
+		&adjustment.Status, &adjustment.CreatedBy, &adjustment.CreatedAt, &reviewedBy, &reviewedAt)
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	adjustment.ReviewedBy = int(reviewedBy.Int64)
+	if reviewedAt.Valid {
+		adjustment.ReviewedAt = &reviewedAt.Time
+	}
+	return adjustment, nil
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+)
+
+const maxSearchResults = 100
+
+// GetUserAccess returns the role of the user and whether the user is blocked.
+func (r *Repo) GetUserAccess(userID int) (string, bool, error) {
+	var role string
+	var blocked bool
+	query := "SELECT role, blocked_at IS NOT NULL FROM users WHERE id = $1 AND deleted_at IS NULL"
+	err := r.db.QueryRow(query, userID).Scan(&role, &blocked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, errs.ErrUserNotFound
+	}
+	return role, blocked, err
+}
+
+// SyncAdmins makes the users with the given logins, and only them, admins. Demoted admins are
+// logged out, since their tokens still carry the role. It returns how many of the logins were
+// found and how many admins were demoted.
+func (r *Repo) SyncAdmins(logins []string) (int64, int64, error) {
+	if logins == nil {
+		// NULL instead of an empty array would match no admins to demote
+		logins = []string{}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE users SET role = $2 WHERE login = ANY ($1) AND deleted_at IS NULL"
+	res, err := tx.Exec(query, logins, model.RoleAdmin)
+	if err != nil {
+		return 0, 0, err
+	}
+	promoted, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	query = `
+		WITH demoted AS (
+			UPDATE users SET role = $2 WHERE role = $3 AND login <> ALL ($1)
+			RETURNING id
+		), revoked AS (
+			UPDATE sessions SET revoked_at = now()
+			WHERE user_id IN (SELECT id FROM demoted) AND revoked_at IS NULL
+		)
+		SELECT count(*) FROM demoted`
+	var demoted int64
+	if err = tx.QueryRow(query, logins, model.RoleUser, model.RoleAdmin).Scan(&demoted); err != nil {
+		return 0, 0, err
+	}
+	return promoted, demoted, tx.Commit()
+}
+
+// SearchUsers returns users whose login contains the substring, case-insensitively.
+func (r *Repo) SearchUsers(login string) ([]model.AdminUser, error) {
+	query := `
+		SELECT id, login, role, blocked_at, deleted_at FROM users
+		WHERE position(lower($1) IN lower(login)) > 0
+		ORDER BY id LIMIT $2`
+	rows, err := r.db.Query(query, login, maxSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]model.AdminUser, 0)
+	for rows.Next() {
+		var user model.AdminUser
+		var blockedAt, deletedAt sql.NullTime
+		if err = rows.Scan(&user.ID, &user.Login, &user.Role, &blockedAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		if blockedAt.Valid {
+			user.BlockedAt = &blockedAt.Time
+		}
+		if deletedAt.Valid {
+			user.DeletedAt = &deletedAt.Time
+		}
+		users = append(users, user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SearchOrders returns orders of all users matching the filter. Empty filter fields match anything.
+func (r *Repo) SearchOrders(filter model.AdminOrderFilter) ([]model.AdminOrder, error) {
+	query := `
+		SELECT order_id, user_id, coalesce(partner_id, 0), status, accrual, uploaded_at FROM orders
+		WHERE ($1 = '' OR order_id = $1) AND ($2 = 0 OR user_id = $2) AND ($3 = '' OR status::text = $3)
+		ORDER BY uploaded_at DESC LIMIT $4`
+	rows, err := r.db.Query(query, filter.Number, filter.UserID, filter.Status, maxSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]model.AdminOrder, 0)
+	for rows.Next() {
+		var order model.AdminOrder
+		err = rows.Scan(&order.Order.Order, &order.UserID, &order.PartnerID, &order.Status, &order.Accrual, &order.UploadedAt)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// RequeueOrder resets the order status to NEW so that the accrual is requested again, and
+// returns the order event recorded for the change, if any. PROCESSED orders can't be requeued,
+// since their accrual is already credited to the balance.
+func (r *Repo) RequeueOrder(order string) (*model.OrderEvent, error) {
+	query := `
+		WITH requeued AS (
+			UPDATE orders SET status = 'NEW'
+			WHERE order_id = $1 AND status IN ('PROCESSING', 'INVALID')
+			RETURNING user_id, order_id, status, accrual
+		)
+		INSERT INTO order_events (user_id, order_id, status, accrual)
+		SELECT user_id, order_id, status, accrual FROM requeued
+		RETURNING id, user_id, order_id, status, coalesce(accrual, 0), created_at`
+	var event model.OrderEvent
+	err := r.db.QueryRow(query, order).Scan(
+		&event.ID, &event.UserID, &event.Order, &event.Status, &event.Accrual, &event.ChangedAt,
+	)
+	if err == nil {
+		return &event, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var status string
+	err = r.db.QueryRow("SELECT status FROM orders WHERE order_id = $1", order).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != "NEW" {
+		return nil, errs.ErrOrderNotRequeueable
+	}
+	// already waiting for the accrual
+	return nil, nil
+}
+
+// SetUserBlocked blocks or unblocks the user. Blocking also revokes all sessions of the user.
+func (r *Repo) SetUserBlocked(userID int, blocked bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users SET blocked_at = CASE WHEN $2 THEN coalesce(blocked_at, now()) END
+		WHERE id = $1 AND deleted_at IS NULL`
+	res, err := tx.Exec(query, userID, blocked)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errs.ErrUserNotFound
+	}
+
+	if blocked {
+		if err = revokeUserSessions(tx, userID, ""); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *Repo) AddAdminAudit(adminID int, action string, target string, details []byte) error {
+	query := "INSERT INTO admin_audit (admin_id, action, target, details) VALUES ($1, $2, $3, nullif($4, '')::jsonb)"
+	_, err := r.db.Exec(query, adminID, action, target, string(details))
+	return err
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+)
+
+func (r *Repo) CreateAPIKey(userID int, key model.APIKey, keyHash string) (model.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	err := r.db.QueryRow(query, userID, key.Name, key.Prefix, keyHash, key.Scopes).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (r *Repo) GetAPIKeys(userID int) ([]model.APIKey, error) {
+	query := `
+		SELECT id, name, prefix, scopes, created_at, last_used_at FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]model.APIKey, 0)
+	for rows.Next() {
+		var key model.APIKey
+		var lastUsedAt sql.NullTime
+		err = rows.Scan(&key.ID, &key.Name, &key.Prefix, r.types.SQLScanner(&key.Scopes), &key.CreatedAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *Repo) RevokeAPIKey(userID int, keyID int) error {
+	query := "UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL"
+	res, err := r.db.Exec(query, keyID, userID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return errs.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the owner and scopes of an active key. Keys of blocked
+// or deleted users are rejected. The last use time is updated at most once a minute.
+func (r *Repo) AuthenticateAPIKey(keyHash string) (int, []string, error) {
+	query := `
+		SELECT k.id, k.user_id, k.scopes, k.last_used_at IS NULL OR k.last_used_at < now() - interval '1 minute'
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND u.blocked_at IS NULL AND u.deleted_at IS NULL`
+	var keyID, userID int
+	var scopes []string
+	var stale bool
+	err := r.db.QueryRow(query, keyHash).Scan(&keyID, &userID, r.types.SQLScanner(&scopes), &stale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, errs.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if stale {
+		if _, err = r.db.Exec("UPDATE api_keys SET last_used_at = now() WHERE id = $1", keyID); err != nil {
+			return 0, nil, err
+		}
+	}
+	return userID, scopes, nil
+}
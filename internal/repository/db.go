@@ -1,21 +1,116 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/kuznet1/gophermart/internal/config"
 	"github.com/kuznet1/gophermart/internal/logger"
+	"go.uber.org/zap"
+	"time"
 )
 
-func InitDBConnection(cfg config.Config) (*sql.DB, error) {
-	db, err := sql.Open("pgx", cfg.DatabaseURI)
+const maxConnectBackoff = 30 * time.Second
+
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// InitDBConnection opens a pgx connection pool configured from cfg, waits until the
+// database is reachable and applies the migrations. The returned func closes the database
+// along with the pool, which closing the *sql.DB alone leaves open.
+func InitDBConnection(cfg config.Config) (*sql.DB, func(), error) {
+	poolConfig, err := newPoolConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to init pool: %w", err)
+	}
+	if err = waitForDB(pool.Ping, cfg.DBConnectAttempts, cfg.DBConnectBackoff); err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+
+	db := stdlib.OpenDBFromPool(pool)
+	closeDB := func() {
+		if err := db.Close(); err != nil {
+			logger.Log.Error("failed to close database", zap.Error(err))
+		}
+		pool.Close()
+	}
+	if err = applyMigrations(db, cfg.MigrationsPath); err != nil {
+		closeDB()
+		return nil, nil, err
+	}
+	return db, closeDB, nil
+}
+
+// newPoolConfig applies the pool settings of cfg over the ones of the database URI.
+// Zero settings are left to the URI and the pgx defaults.
+func newPoolConfig(cfg config.Config) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseURI)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid database URI: %w", err)
+	}
+
+	if cfg.DBMaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.DBMaxConns)
+	}
+	if cfg.DBMinConns > 0 {
+		poolConfig.MinConns = int32(min(cfg.DBMinConns, int(poolConfig.MaxConns)))
+	}
+	if cfg.DBMaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.DBMaxConnLifetime
+	}
+	if cfg.DBMaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.DBMaxConnIdleTime
 	}
+	if cfg.DBHealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.DBHealthCheckPeriod
+	}
+	if cfg.DBConnectTimeout > 0 {
+		poolConfig.ConnConfig.ConnectTimeout = cfg.DBConnectTimeout
+	}
+	if cfg.DBStatementCacheMode != "" {
+		mode, ok := queryExecModes[cfg.DBStatementCacheMode]
+		if !ok {
+			return nil, fmt.Errorf("invalid statement cache mode: %q", cfg.DBStatementCacheMode)
+		}
+		poolConfig.ConnConfig.DefaultQueryExecMode = mode
+	}
+	if cfg.DBStatementCacheSize > 0 {
+		poolConfig.ConnConfig.StatementCacheCapacity = cfg.DBStatementCacheSize
+		poolConfig.ConnConfig.DescriptionCacheCapacity = cfg.DBStatementCacheSize
+	}
+	return poolConfig, nil
+}
 
-	return db, applyMigrations(db, cfg.MigrationsPath)
+// waitForDB pings the database until it responds, doubling the delay between the attempts.
+func waitForDB(ping func(context.Context) error, attempts int, backoff time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := ping(context.Background())
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("database is unreachable after %d attempts: %w", attempt, err)
+		}
+		logger.Log.Warn("database is unreachable, retrying",
+			zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
 }
 
 func applyMigrations(db *sql.DB, path string) error {
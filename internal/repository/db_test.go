@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewPoolConfig(t *testing.T) {
+	const uri = "postgres://gophermart@localhost:5432/gophermart?pool_max_conns=7&pool_min_conns=2"
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		valid  bool
+		verify func(t *testing.T, pool *pgxpool.Config)
+	}{
+		{
+			name:  "URI settings are kept",
+			cfg:   config.Config{DatabaseURI: uri},
+			valid: true,
+			verify: func(t *testing.T, pool *pgxpool.Config) {
+				require.Equal(t, int32(7), pool.MaxConns)
+				require.Equal(t, int32(2), pool.MinConns)
+				require.Equal(t, pgx.QueryExecModeCacheStatement, pool.ConnConfig.DefaultQueryExecMode)
+			},
+		},
+		{
+			name: "config settings override the URI",
+			cfg: config.Config{
+				DatabaseURI:          uri,
+				DBMaxConns:           20,
+				DBMinConns:           5,
+				DBMaxConnLifetime:    time.Minute,
+				DBMaxConnIdleTime:    time.Second,
+				DBHealthCheckPeriod:  10 * time.Second,
+				DBConnectTimeout:     3 * time.Second,
+				DBStatementCacheMode: "simple_protocol",
+				DBStatementCacheSize: 64,
+			},
+			valid: true,
+			verify: func(t *testing.T, pool *pgxpool.Config) {
+				require.Equal(t, int32(20), pool.MaxConns)
+				require.Equal(t, int32(5), pool.MinConns)
+				require.Equal(t, time.Minute, pool.MaxConnLifetime)
+				require.Equal(t, time.Second, pool.MaxConnIdleTime)
+				require.Equal(t, 10*time.Second, pool.HealthCheckPeriod)
+				require.Equal(t, 3*time.Second, pool.ConnConfig.ConnectTimeout)
+				require.Equal(t, pgx.QueryExecModeSimpleProtocol, pool.ConnConfig.DefaultQueryExecMode)
+				require.Equal(t, 64, pool.ConnConfig.StatementCacheCapacity)
+				require.Equal(t, 64, pool.ConnConfig.DescriptionCacheCapacity)
+			},
+		},
+		{
+			name:  "min conns are capped at max conns",
+			cfg:   config.Config{DatabaseURI: uri, DBMinConns: 10},
+			valid: true,
+			verify: func(t *testing.T, pool *pgxpool.Config) {
+				require.Equal(t, int32(7), pool.MinConns)
+			},
+		},
+		{
+			name: "invalid URI",
+			cfg:  config.Config{DatabaseURI: "postgres://localhost:port"},
+		},
+		{
+			name: "invalid statement cache mode",
+			cfg:  config.Config{DatabaseURI: uri, DBStatementCacheMode: "prepare"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poolConfig, err := newPoolConfig(tt.cfg)
+			if !tt.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.verify(t, poolConfig)
+		})
+	}
+}
+
+func TestWaitForDB(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		attempts int
+		pings    int
+		valid    bool
+	}{
+		{"reachable", 0, 1, 1, true},
+		{"reachable after retries", 2, 5, 3, true},
+		{"reachable on the last attempt", 4, 5, 5, true},
+		{"unreachable", 5, 5, 5, false},
+		{"single attempt", 1, 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pings := 0
+			ping := func(context.Context) error {
+				pings++
+				if pings <= tt.failures {
+					return errors.New("connection refused")
+				}
+				return nil
+			}
+
+			err := waitForDB(ping, tt.attempts, time.Millisecond)
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, "connection refused")
+			}
+			require.Equal(t, tt.pings, pings)
+		})
+	}
+}
@@ -0,0 +1,924 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAmountCents mirrors the numeric(8, 2) columns of amounts.
+const maxAmountCents = 1e8
+
+var (
+	orderNumberRe = regexp.MustCompile(`^[0-9]+$`)
+	orderStatuses = []string{"NEW", "PROCESSING", "INVALID", "PROCESSED"}
+)
+
+// MemRepo is a Storage kept in memory, for local development without a database.
+// It follows the semantics of Repo, including the errors returned, the ordering and
+// limits of listings and the rounding of amounts to cents. All the data is lost on exit.
+type MemRepo struct {
+	mu sync.Mutex
+
+	users  map[int]*memUser
+	logins map[string]int
+	lastID map[string]int
+
+	orders         map[string]*memOrder
+	orderAudit     []memOrderAudit
+	orderEvents    []model.OrderEvent
+	withdrawals    []memWithdrawal
+	adjustments    []*model.BalanceAdjustment
+	adminAudit     []memAdminAudit
+	passwordResets map[string]*memPasswordReset
+	recoveryCodes  map[int]map[string]bool
+	usedChallenges map[string]time.Time
+
+	sessions      map[string]*memSession
+	refreshTokens map[string]*memRefreshToken
+	loginThrottle map[string]*memLoginThrottle
+
+	webhooks   map[int]*memWebhook
+	deliveries []*model.WebhookDelivery
+
+	apiKeys          map[int]*memAPIKey
+	partners         map[int]*model.Partner
+	revokedPartners  map[int]bool
+	partnerCustomers map[memPartnerCustomer]int
+	partnerNonces    map[memPartnerNonce]time.Time
+}
+
+type memUser struct {
+	id          int
+	login       string
+	password    string
+	role        string
+	blockedAt   *time.Time
+	deletedAt   *time.Time
+	dataVersion int64
+
+	totpSecret      string
+	totpEnabled     bool
+	totpLastCounter *int64
+}
+
+type memOrder struct {
+	id         int
+	order      string
+	userID     int
+	partnerID  int
+	status     string
+	accrual    float64
+	uploadedAt time.Time
+}
+
+type memOrderAudit struct {
+	order      string
+	userID     int
+	action     string
+	status     string
+	uploadedAt time.Time
+	createdAt  time.Time
+}
+
+type memWithdrawal struct {
+	userID int
+	model.Withdrawal
+}
+
+type memAdminAudit struct {
+	adminID   int
+	action    string
+	target    string
+	details   []byte
+	createdAt time.Time
+}
+
+type memPasswordReset struct {
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+func NewMemRepo() *MemRepo {
+	return &MemRepo{
+		users:            make(map[int]*memUser),
+		logins:           make(map[string]int),
+		lastID:           make(map[string]int),
+		orders:           make(map[string]*memOrder),
+		passwordResets:   make(map[string]*memPasswordReset),
+		recoveryCodes:    make(map[int]map[string]bool),
+		usedChallenges:   make(map[string]time.Time),
+		sessions:         make(map[string]*memSession),
+		refreshTokens:    make(map[string]*memRefreshToken),
+		loginThrottle:    make(map[string]*memLoginThrottle),
+		webhooks:         make(map[int]*memWebhook),
+		apiKeys:          make(map[int]*memAPIKey),
+		partners:         make(map[int]*model.Partner),
+		revokedPartners:  make(map[int]bool),
+		partnerCustomers: make(map[memPartnerCustomer]int),
+		partnerNonces:    make(map[memPartnerNonce]time.Time),
+	}
+}
+
+// nextID returns the next value of the named sequence, like a SERIAL column.
+func (r *MemRepo) nextID(sequence string) int {
+	r.lastID[sequence]++
+	return r.lastID[sequence]
+}
+
+// activeUser returns the user unless it doesn't exist or is deleted.
+func (r *MemRepo) activeUser(userID int) (*memUser, bool) {
+	user, ok := r.users[userID]
+	if !ok || user.deletedAt != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// bumpDataVersion does what the bump_user_data_version trigger does on changes of
+// the user's orders, withdrawals and balance adjustments.
+func (r *MemRepo) bumpDataVersion(userID int) {
+	if user, ok := r.users[userID]; ok {
+		user.dataVersion++
+	}
+}
+
+func (r *MemRepo) Register(login string, passwordHash string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.logins[login]; ok {
+		return 0, errs.ErrUserExists
+	}
+	user := &memUser{id: r.nextID("users"), login: login, password: passwordHash, role: model.RoleUser}
+	r.users[user.id] = user
+	r.logins[login] = user.id
+	return user.id, nil
+}
+
+func (r *MemRepo) GetUserCredentials(login string) (int, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(r.logins[login])
+	if !ok {
+		return 0, "", errs.ErrUserCredentials
+	}
+	return user.id, user.password, nil
+}
+
+func (r *MemRepo) GetUserCredentialsByID(userID int) (string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(userID)
+	if !ok {
+		return "", "", errs.ErrUserCredentials
+	}
+	return user.login, user.password, nil
+}
+
+func (r *MemRepo) UpdatePasswordHash(userID int, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		user.password = passwordHash
+	}
+	return nil
+}
+
+func (r *MemRepo) SetPassword(userID int, passwordHash string, keepSessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		user.password = passwordHash
+	}
+	r.revokeUserSessions(userID, keepSessionID)
+	return nil
+}
+
+func (r *MemRepo) CreatePasswordReset(userID int, tokenHash string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.passwordResets[tokenHash]; ok {
+		return fmt.Errorf("password reset token already exists")
+	}
+	for _, reset := range r.passwordResets {
+		if reset.userID == userID {
+			reset.used = true
+		}
+	}
+	r.passwordResets[tokenHash] = &memPasswordReset{userID: userID, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (r *MemRepo) GetPasswordResetUser(tokenHash string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset, ok := r.validPasswordReset(tokenHash)
+	if !ok {
+		return 0, errs.ErrInvalidResetToken
+	}
+	return reset.userID, nil
+}
+
+func (r *MemRepo) ResetPassword(tokenHash string, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset, ok := r.validPasswordReset(tokenHash)
+	if !ok {
+		return errs.ErrInvalidResetToken
+	}
+	reset.used = true
+	if user, ok := r.users[reset.userID]; ok {
+		user.password = passwordHash
+	}
+	r.revokeUserSessions(reset.userID, "")
+	return nil
+}
+
+func (r *MemRepo) validPasswordReset(tokenHash string) (*memPasswordReset, bool) {
+	reset, ok := r.passwordResets[tokenHash]
+	if !ok || reset.used || !reset.expiresAt.After(time.Now()) {
+		return nil, false
+	}
+	return reset, true
+}
+
+func (r *MemRepo) DeleteUser(userID int, retention string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(userID)
+	if !ok {
+		return errs.ErrUserCredentials
+	}
+	switch retention {
+	case "anonymize", "delete":
+	default:
+		return fmt.Errorf("unknown retention policy: %q", retention)
+	}
+	// The record of a user who acted as an admin is kept anonymized, so that the admin records
+	// stay attributed.
+	anonymize := retention == "anonymize" || r.referencedByAdminRecords(userID)
+	anonymized := model.AnonymizedLoginPrefix + strconv.Itoa(userID)
+	if _, ok = r.logins[anonymized]; ok && anonymize {
+		return fmt.Errorf("login %q already exists", anonymized)
+	}
+
+	for hash, token := range r.refreshTokens {
+		if r.sessions[token.sessionID].userID == userID {
+			delete(r.refreshTokens, hash)
+		}
+	}
+	for id, session := range r.sessions {
+		if session.userID == userID {
+			delete(r.sessions, id)
+		}
+	}
+	for hash, reset := range r.passwordResets {
+		if reset.userID == userID {
+			delete(r.passwordResets, hash)
+		}
+	}
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(delivery *model.WebhookDelivery) bool {
+		return r.webhooks[delivery.WebhookID].userID == userID
+	})
+	for id, webhook := range r.webhooks {
+		if webhook.userID == userID {
+			delete(r.webhooks, id)
+		}
+	}
+	r.orderEvents = slices.DeleteFunc(r.orderEvents, func(event model.OrderEvent) bool {
+		return event.UserID == userID
+	})
+	for id, key := range r.apiKeys {
+		if key.userID == userID {
+			delete(r.apiKeys, id)
+		}
+	}
+	for customer, customerUserID := range r.partnerCustomers {
+		if customerUserID == userID {
+			delete(r.partnerCustomers, customer)
+		}
+	}
+	delete(r.recoveryCodes, userID)
+
+	if retention == "delete" {
+		r.orderAudit = slices.DeleteFunc(r.orderAudit, func(audit memOrderAudit) bool {
+			return audit.userID == userID
+		})
+		for order, o := range r.orders {
+			if o.userID == userID {
+				delete(r.orders, order)
+			}
+		}
+		r.withdrawals = slices.DeleteFunc(r.withdrawals, func(withdrawal memWithdrawal) bool {
+			return withdrawal.userID == userID
+		})
+		r.adjustments = slices.DeleteFunc(r.adjustments, func(adjustment *model.BalanceAdjustment) bool {
+			return adjustment.UserID == userID
+		})
+	}
+
+	delete(r.logins, user.login)
+	if !anonymize {
+		delete(r.users, userID)
+		return nil
+	}
+	r.logins[anonymized] = userID
+	now := time.Now()
+	user.login, user.password, user.totpSecret, user.deletedAt = anonymized, "", "", &now
+	return nil
+}
+
+func (r *MemRepo) referencedByAdminRecords(userID int) bool {
+	for _, audit := range r.adminAudit {
+		if audit.adminID == userID {
+			return true
+		}
+	}
+	for _, adjustment := range r.adjustments {
+		if adjustment.UserID != userID && (adjustment.CreatedBy == userID || adjustment.ReviewedBy == userID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemRepo) GetUserAccess(userID int) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(userID)
+	if !ok {
+		return "", false, errs.ErrUserNotFound
+	}
+	return user.role, user.blockedAt != nil, nil
+}
+
+func (r *MemRepo) SyncAdmins(logins []string) (int64, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var promoted, demoted int64
+	for _, user := range r.users {
+		switch {
+		case slices.Contains(logins, user.login):
+			if user.deletedAt == nil {
+				user.role = model.RoleAdmin
+				promoted++
+			}
+		case user.role == model.RoleAdmin:
+			user.role = model.RoleUser
+			r.revokeUserSessions(user.id, "")
+			demoted++
+		}
+	}
+	return promoted, demoted, nil
+}
+
+func (r *MemRepo) SearchUsers(login string) ([]model.AdminUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]model.AdminUser, 0)
+	for _, user := range r.users {
+		if strings.Contains(strings.ToLower(user.login), strings.ToLower(login)) {
+			users = append(users, model.AdminUser{
+				ID:        user.id,
+				Login:     user.login,
+				Role:      user.role,
+				BlockedAt: copyTime(user.blockedAt),
+				DeletedAt: copyTime(user.deletedAt),
+			})
+		}
+	}
+	slices.SortFunc(users, func(a, b model.AdminUser) int {
+		return a.ID - b.ID
+	})
+	return users[:min(len(users), maxSearchResults)], nil
+}
+
+func (r *MemRepo) SetUserBlocked(userID int, blocked bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(userID)
+	if !ok {
+		return errs.ErrUserNotFound
+	}
+	if !blocked {
+		user.blockedAt = nil
+		return nil
+	}
+	if user.blockedAt == nil {
+		now := time.Now()
+		user.blockedAt = &now
+	}
+	r.revokeUserSessions(userID, "")
+	return nil
+}
+
+func (r *MemRepo) AddAdminAudit(adminID int, action string, target string, details []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(details) > 0 && !json.Valid(details) {
+		return fmt.Errorf("invalid audit details: %s", details)
+	}
+	r.adminAudit = append(r.adminAudit, memAdminAudit{
+		adminID:   adminID,
+		action:    action,
+		target:    target,
+		details:   slices.Clone(details),
+		createdAt: time.Now(),
+	})
+	return nil
+}
+
+func (r *MemRepo) GetDataVersion(userID int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return 0, errs.ErrUserNotFound
+	}
+	return user.dataVersion, nil
+}
+
+func (r *MemRepo) AddOrder(userID int, orderNum string, partnerID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := checkOrderNumber(orderNum); err != nil {
+		return err
+	}
+	if o, ok := r.orders[orderNum]; ok {
+		return orderOwnerErr(userID, o.userID)
+	}
+	r.insertOrder(userID, orderNum, partnerID)
+	return nil
+}
+
+func (r *MemRepo) AddOrders(userID int, orderNums []string) (map[string]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, order := range orderNums {
+		if err := checkOrderNumber(order); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make(map[string]error, len(orderNums))
+	for _, order := range orderNums {
+		if _, ok := results[order]; ok {
+			continue
+		}
+		if o, ok := r.orders[order]; ok {
+			results[order] = orderOwnerErr(userID, o.userID)
+			continue
+		}
+		r.insertOrder(userID, order, 0)
+		results[order] = nil
+	}
+	return results, nil
+}
+
+func (r *MemRepo) insertOrder(userID int, orderNum string, partnerID int) {
+	r.orders[orderNum] = &memOrder{
+		id:         r.nextID("orders"),
+		order:      orderNum,
+		userID:     userID,
+		partnerID:  partnerID,
+		status:     "NEW",
+		uploadedAt: time.Now(),
+	}
+	r.bumpDataVersion(userID)
+}
+
+func (r *MemRepo) CancelOrder(userID int, orderNum string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.orders[orderNum]
+	if !ok || o.userID != userID {
+		return errs.ErrOrderNotFound
+	}
+	if o.status != "NEW" && o.status != "INVALID" {
+		return errs.ErrOrderNotCancellable
+	}
+
+	delete(r.orders, orderNum)
+	r.orderAudit = append(r.orderAudit, memOrderAudit{
+		order:      o.order,
+		userID:     o.userID,
+		action:     "CANCELLED",
+		status:     o.status,
+		uploadedAt: o.uploadedAt,
+		createdAt:  time.Now(),
+	})
+	r.bumpDataVersion(userID)
+	return nil
+}
+
+func (r *MemRepo) GetOrders(userID int) ([]model.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orders := make([]model.Order, 0)
+	for _, o := range r.sortedOrders() {
+		if o.userID == userID {
+			orders = append(orders, o.model().Order)
+		}
+	}
+	return orders, nil
+}
+
+func (r *MemRepo) SearchOrders(filter model.AdminOrderFilter) ([]model.AdminOrder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orders := make([]model.AdminOrder, 0)
+	for _, o := range r.sortedOrders() {
+		if (filter.Number == "" || o.order == filter.Number) && (filter.UserID == 0 || o.userID == filter.UserID) &&
+			(filter.Status == "" || o.status == filter.Status) {
+			orders = append(orders, o.model())
+		}
+	}
+	return orders[:min(len(orders), maxSearchResults)], nil
+}
+
+// sortedOrders returns all the orders, most recently uploaded first.
+func (r *MemRepo) sortedOrders() []*memOrder {
+	orders := make([]*memOrder, 0, len(r.orders))
+	for _, o := range r.orders {
+		orders = append(orders, o)
+	}
+	slices.SortFunc(orders, func(a, b *memOrder) int {
+		if c := b.uploadedAt.Compare(a.uploadedAt); c != 0 {
+			return c
+		}
+		return b.id - a.id
+	})
+	return orders
+}
+
+func (o *memOrder) model() model.AdminOrder {
+	return model.AdminOrder{
+		Order: model.Order{
+			Order:      o.order,
+			Status:     o.status,
+			Accrual:    o.accrual,
+			UploadedAt: o.uploadedAt,
+		},
+		UserID:    o.userID,
+		PartnerID: o.partnerID,
+	}
+}
+
+func (r *MemRepo) RequeueOrder(order string) (*model.OrderEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.orders[order]
+	switch {
+	case !ok:
+		return nil, errs.ErrOrderNotFound
+	case o.status == "NEW":
+		return nil, nil
+	case o.status == "PROCESSED":
+		return nil, errs.ErrOrderNotRequeueable
+	}
+
+	o.status = "NEW"
+	r.bumpDataVersion(o.userID)
+	event := model.OrderEvent{
+		ID:        int64(r.nextID("order_events")),
+		UserID:    o.userID,
+		Order:     o.order,
+		Status:    o.status,
+		Accrual:   o.accrual,
+		ChangedAt: time.Now(),
+	}
+	r.orderEvents = append(r.orderEvents, event)
+	return &event, nil
+}
+
+func (r *MemRepo) GetProcessingOrders() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var queued []*memOrder
+	for _, o := range r.orders {
+		if o.status == "NEW" || o.status == "PROCESSING" {
+			queued = append(queued, o)
+		}
+	}
+	slices.SortFunc(queued, func(a, b *memOrder) int {
+		return a.id - b.id
+	})
+
+	var orders []string
+	for _, o := range queued {
+		orders = append(orders, o.order)
+	}
+	return orders, nil
+}
+
+func (r *MemRepo) UpdateAccrual(accrual model.AccrualResp) (*model.OrderEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !slices.Contains(orderStatuses, accrual.Status) {
+		return nil, fmt.Errorf("invalid order status: %q", accrual.Status)
+	}
+	amount, err := roundAmount(accrual.Accrual)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := r.orders[accrual.Order]
+	if !ok || (o.status == accrual.Status && o.accrual == amount) {
+		return nil, nil
+	}
+
+	o.status = accrual.Status
+	o.accrual = amount
+	r.bumpDataVersion(o.userID)
+
+	event := model.OrderEvent{
+		ID:        int64(r.nextID("order_events")),
+		UserID:    o.userID,
+		Order:     o.order,
+		Status:    o.status,
+		Accrual:   o.accrual,
+		ChangedAt: time.Now(),
+	}
+	r.orderEvents = append(r.orderEvents, event)
+	return &event, nil
+}
+
+func (r *MemRepo) GetOrderEvents(userID int, afterID int64) ([]model.OrderEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]model.OrderEvent, 0)
+	for _, event := range r.orderEvents {
+		if event.UserID == userID && event.ID > afterID {
+			events = append(events, event)
+			if len(events) == maxReplayedEvents {
+				break
+			}
+		}
+	}
+	return events, nil
+}
+
+func (r *MemRepo) NewWithdrawal(userID int, withdraws model.Withdraw, totpCounter *int64, event *model.WebhookEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if withdraws.Sum > r.balance(userID).Current {
+		return errs.ErrBalanceNotEnoughPoints
+	}
+	user := r.users[userID]
+	if totpCounter != nil && (user == nil || (user.totpLastCounter != nil && *user.totpLastCounter >= *totpCounter)) {
+		return errs.ErrInvalidTOTPCode
+	}
+	if err := checkOrderNumber(withdraws.Order); err != nil {
+		return err
+	}
+	sum, err := roundAmount(withdraws.Sum)
+	if err != nil {
+		return err
+	}
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.Order == withdraws.Order {
+			return fmt.Errorf("withdrawal for order %s already exists", withdraws.Order)
+		}
+	}
+	if event != nil && !json.Valid(event.Payload) {
+		return fmt.Errorf("invalid webhook payload: %s", event.Payload)
+	}
+
+	if totpCounter != nil {
+		counter := *totpCounter
+		user.totpLastCounter = &counter
+	}
+	r.withdrawals = append(r.withdrawals, memWithdrawal{
+		userID:     userID,
+		Withdrawal: model.Withdrawal{Order: withdraws.Order, Sum: sum, ProcessedAt: time.Now()},
+	})
+	r.bumpDataVersion(userID)
+	if event != nil {
+		// can't fail, the payload is checked above
+		r.enqueueWebhookEvent(userID, event.Event, event.Payload)
+	}
+	return nil
+}
+
+func (r *MemRepo) GetWithdrawals(userID int) ([]model.Withdrawal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	withdrawals := make([]model.Withdrawal, 0)
+	for i := len(r.withdrawals) - 1; i >= 0; i-- {
+		if r.withdrawals[i].userID == userID {
+			withdrawals = append(withdrawals, r.withdrawals[i].Withdrawal)
+		}
+	}
+	return withdrawals, nil
+}
+
+func (r *MemRepo) GetBalance(userID int) (model.Balance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.balance(userID), nil
+}
+
+// balance sums up the amounts in cents, so that the result is as exact as the numeric sums in Postgres.
+func (r *MemRepo) balance(userID int) model.Balance {
+	var accruals, withdrawals, adjustments int64
+	for _, o := range r.orders {
+		if o.userID == userID {
+			accruals += cents(o.accrual)
+		}
+	}
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.userID == userID {
+			withdrawals += cents(withdrawal.Sum)
+		}
+	}
+	for _, adjustment := range r.adjustments {
+		if adjustment.UserID == userID && adjustment.Status == model.AdjustmentApplied {
+			adjustments += cents(adjustment.Amount)
+		}
+	}
+	return model.Balance{
+		Current:   float64(accruals+adjustments-withdrawals) / 100,
+		Withdrawn: float64(withdrawals) / 100,
+	}
+}
+
+func (r *MemRepo) CreateAdjustment(adjustment model.BalanceAdjustment) (model.BalanceAdjustment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	amount, err := roundAmount(adjustment.Amount)
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	if amount == 0 || !slices.Contains(model.AdjustmentReasons, adjustment.Reason) ||
+		(adjustment.Status != model.AdjustmentPending && adjustment.Status != model.AdjustmentApplied &&
+			adjustment.Status != model.AdjustmentRejected) {
+		return model.BalanceAdjustment{}, fmt.Errorf("invalid balance adjustment")
+	}
+	if _, ok := r.activeUser(adjustment.UserID); !ok {
+		return model.BalanceAdjustment{}, errs.ErrUserNotFound
+	}
+
+	created := &model.BalanceAdjustment{
+		ID:        r.nextID("balance_adjustments"),
+		UserID:    adjustment.UserID,
+		Amount:    amount,
+		Reason:    adjustment.Reason,
+		Comment:   adjustment.Comment,
+		Status:    adjustment.Status,
+		CreatedBy: adjustment.CreatedBy,
+		CreatedAt: time.Now(),
+	}
+	r.adjustments = append(r.adjustments, created)
+	r.bumpDataVersion(created.UserID)
+	return *created, nil
+}
+
+func (r *MemRepo) GetAdjustments(status string) ([]model.BalanceAdjustment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	adjustments := make([]model.BalanceAdjustment, 0)
+	for i := len(r.adjustments) - 1; i >= 0 && len(adjustments) < maxSearchResults; i-- {
+		if status == "" || r.adjustments[i].Status == status {
+			adjustments = append(adjustments, copyAdjustment(r.adjustments[i]))
+		}
+	}
+	return adjustments, nil
+}
+
+func (r *MemRepo) GetUserAdjustments(userID int) ([]model.BalanceAdjustment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	adjustments := make([]model.BalanceAdjustment, 0)
+	for i := len(r.adjustments) - 1; i >= 0; i-- {
+		adjustment := r.adjustments[i]
+		if adjustment.UserID != userID || adjustment.Status != model.AdjustmentApplied {
+			continue
+		}
+		appliedAt := adjustment.CreatedAt
+		if adjustment.ReviewedAt != nil {
+			appliedAt = *adjustment.ReviewedAt
+		}
+		adjustments = append(adjustments, model.BalanceAdjustment{
+			ID:        adjustment.ID,
+			Amount:    adjustment.Amount,
+			Reason:    adjustment.Reason,
+			Status:    adjustment.Status,
+			CreatedAt: appliedAt,
+		})
+	}
+	return adjustments, nil
+}
+
+func (r *MemRepo) ReviewAdjustment(adjustmentID int, reviewerID int, approve bool) (model.BalanceAdjustment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.adjustments, func(adjustment *model.BalanceAdjustment) bool {
+		return adjustment.ID == adjustmentID
+	})
+	if i < 0 {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentNotFound
+	}
+	adjustment := r.adjustments[i]
+	if adjustment.Status != model.AdjustmentPending {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentReviewed
+	}
+	if adjustment.CreatedBy == reviewerID || adjustment.UserID == reviewerID {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentSelfApproval
+	}
+
+	adjustment.Status = model.AdjustmentRejected
+	if approve {
+		adjustment.Status = model.AdjustmentApplied
+	}
+	now := time.Now()
+	adjustment.ReviewedBy = reviewerID
+	adjustment.ReviewedAt = &now
+	r.bumpDataVersion(adjustment.UserID)
+	return copyAdjustment(adjustment), nil
+}
+
+func copyAdjustment(adjustment *model.BalanceAdjustment) model.BalanceAdjustment {
+	copied := *adjustment
+	copied.ReviewedAt = copyTime(adjustment.ReviewedAt)
+	return copied
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+func checkOrderNumber(order string) error {
+	if !orderNumberRe.MatchString(order) {
+		return fmt.Errorf("invalid order number: %q", order)
+	}
+	return nil
+}
+
+// roundAmount rounds the amount to cents as the numeric(8, 2) columns do.
+func roundAmount(amount float64) (float64, error) {
+	c := cents(amount)
+	if c <= -maxAmountCents || c >= maxAmountCents {
+		return 0, fmt.Errorf("amount out of range: %v", amount)
+	}
+	return float64(c) / 100, nil
+}
+
+// cents converts the amount the way it reaches Postgres: pgx sends the shortest decimal
+// representation of the float, which is rounded half away from zero.
+func cents(amount float64) int64 {
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(math.Abs(amount), 'f', -1, 64), ".")
+	frac += "000"
+	c, err := strconv.ParseInt(whole+frac[:2], 10, 64)
+	if err != nil {
+		c = math.MaxInt64
+	} else if frac[2] >= '5' {
+		c++
+	}
+	if amount < 0 {
+		c = -c
+	}
+	return c
+}
@@ -0,0 +1,490 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"slices"
+	"time"
+)
+
+type memSession struct {
+	model.Session
+	userID    int
+	revokedAt *time.Time
+}
+
+func (s *memSession) active(now time.Time) bool {
+	return s.revokedAt == nil && s.ExpiresAt.After(now)
+}
+
+type memRefreshToken struct {
+	sessionID string
+	used      bool
+}
+
+type memLoginThrottle struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+type memAPIKey struct {
+	model.APIKey
+	userID  int
+	hash    string
+	revoked bool
+}
+
+type memPartnerCustomer struct {
+	partnerID  int
+	externalID string
+}
+
+type memPartnerNonce struct {
+	partnerID int
+	nonce     string
+}
+
+func (r *MemRepo) CreateSession(userID int, sessionID string, refreshHash string, ttl time.Duration, client model.ClientInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[sessionID]; ok {
+		return fmt.Errorf("session %s already exists", sessionID)
+	}
+	if _, ok := r.refreshTokens[refreshHash]; ok {
+		return fmt.Errorf("refresh token already exists")
+	}
+
+	now := time.Now()
+	r.sessions[sessionID] = &memSession{
+		Session: model.Session{
+			ID:         sessionID,
+			UserAgent:  client.UserAgent,
+			IP:         client.IP,
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(ttl),
+		},
+		userID: userID,
+	}
+	r.refreshTokens[refreshHash] = &memRefreshToken{sessionID: sessionID}
+	return nil
+}
+
+func (r *MemRepo) RotateRefreshToken(oldHash string, newHash string, ttl time.Duration) (int, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.refreshTokens[oldHash]
+	if !ok {
+		return 0, "", errs.ErrInvalidRefreshToken
+	}
+	now := time.Now()
+	session := r.sessions[token.sessionID]
+	if token.used {
+		if session.revokedAt == nil {
+			session.revokedAt = &now
+		}
+		return 0, "", errs.ErrInvalidRefreshToken
+	}
+	if !session.active(now) {
+		return 0, "", errs.ErrInvalidRefreshToken
+	}
+	if _, ok = r.refreshTokens[newHash]; ok {
+		return 0, "", fmt.Errorf("refresh token already exists")
+	}
+
+	token.used = true
+	r.refreshTokens[newHash] = &memRefreshToken{sessionID: session.ID}
+	session.ExpiresAt = now.Add(ttl)
+	session.LastSeenAt = now
+	return session.userID, session.ID, nil
+}
+
+func (r *MemRepo) RevokeSession(userID int, sessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	session, ok := r.sessions[sessionID]
+	if !ok || session.userID != userID || !session.active(now) {
+		return errs.ErrSessionNotFound
+	}
+	session.revokedAt = &now
+	return nil
+}
+
+func (r *MemRepo) IsSessionActive(sessionID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[sessionID]
+	if !ok {
+		return false, nil
+	}
+	now := time.Now()
+	active := session.active(now)
+	if active && session.LastSeenAt.Before(now.Add(-time.Minute)) {
+		session.LastSeenAt = now
+	}
+	return active, nil
+}
+
+func (r *MemRepo) GetSessions(userID int) ([]model.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	sessions := make([]model.Session, 0)
+	for _, session := range r.sessions {
+		if session.userID == userID && session.active(now) {
+			sessions = append(sessions, session.Session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b model.Session) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (r *MemRepo) revokeUserSessions(userID int, keepSessionID string) {
+	now := time.Now()
+	for _, session := range r.sessions {
+		if session.userID == userID && session.ID != keepSessionID && session.revokedAt == nil {
+			session.revokedAt = &now
+		}
+	}
+}
+
+func (r *MemRepo) GetLoginLock(keys []string) (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var lock time.Duration
+	for _, key := range keys {
+		if throttle, ok := r.loginThrottle[key]; ok {
+			lock = max(lock, throttle.lockedUntil.Sub(now))
+		}
+	}
+	return lock, nil
+}
+
+func (r *MemRepo) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	throttle, ok := r.loginThrottle[key]
+	if !ok {
+		throttle = &memLoginThrottle{}
+		r.loginThrottle[key] = throttle
+	}
+	if throttle.lastFailureAt.Before(now.Add(-window)) {
+		throttle.failures = 0
+	}
+	throttle.failures++
+	throttle.lastFailureAt = now
+	return throttle.failures, nil
+}
+
+func (r *MemRepo) LockLogin(key string, duration time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	throttle, ok := r.loginThrottle[key]
+	if !ok {
+		return nil
+	}
+	if lockedUntil := time.Now().Add(duration); lockedUntil.After(throttle.lockedUntil) {
+		throttle.lockedUntil = lockedUntil
+	}
+	return nil
+}
+
+func (r *MemRepo) ResetLoginFailures(keys []string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for _, key := range keys {
+		if _, ok := r.loginThrottle[key]; ok {
+			delete(r.loginThrottle, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *MemRepo) GetTOTP(userID int) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(userID)
+	if !ok {
+		return "", false, errs.ErrUserNotFound
+	}
+	return user.totpSecret, user.totpEnabled, nil
+}
+
+func (r *MemRepo) SetTOTPSecret(userID int, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || user.totpEnabled {
+		return errs.ErrTOTPEnabled
+	}
+	user.totpSecret = secret
+	user.totpLastCounter = nil
+	return nil
+}
+
+func (r *MemRepo) EnableTOTP(userID int, counter int64, recoveryHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || user.totpSecret == "" || user.totpEnabled {
+		return errs.ErrTOTPEnabled
+	}
+	user.totpEnabled = true
+	user.totpLastCounter = &counter
+
+	codes := make(map[string]bool, len(recoveryHashes))
+	for _, hash := range recoveryHashes {
+		codes[hash] = false
+	}
+	r.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (r *MemRepo) DisableTOTP(userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		user.totpSecret = ""
+		user.totpEnabled = false
+		user.totpLastCounter = nil
+	}
+	delete(r.recoveryCodes, userID)
+	return nil
+}
+
+func (r *MemRepo) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || (user.totpLastCounter != nil && *user.totpLastCounter >= counter) {
+		return false, nil
+	}
+	user.totpLastCounter = &counter
+	return true, nil
+}
+
+func (r *MemRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recoveryCodes[userID][codeHash] = true
+	return true, nil
+}
+
+func (r *MemRepo) UseChallenge(challengeID string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, expiresAt := range r.usedChallenges {
+		if expiresAt.Before(now) {
+			delete(r.usedChallenges, id)
+		}
+	}
+	if _, ok := r.usedChallenges[challengeID]; ok {
+		return false, nil
+	}
+	r.usedChallenges[challengeID] = now.Add(ttl)
+	return true, nil
+}
+
+func (r *MemRepo) CreateAPIKey(userID int, key model.APIKey, keyHash string) (model.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.apiKeys {
+		if existing.hash == keyHash {
+			return model.APIKey{}, fmt.Errorf("api key already exists")
+		}
+	}
+	key.ID = r.nextID("api_keys")
+	key.CreatedAt = time.Now()
+	stored := key
+	stored.Key = ""
+	stored.Scopes = slices.Clone(key.Scopes)
+	r.apiKeys[key.ID] = &memAPIKey{APIKey: stored, userID: userID, hash: keyHash}
+	return key, nil
+}
+
+func (r *MemRepo) GetAPIKeys(userID int) ([]model.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]model.APIKey, 0)
+	for _, key := range r.apiKeys {
+		if key.userID == userID && !key.revoked {
+			copied := key.APIKey
+			copied.Scopes = slices.Clone(key.Scopes)
+			copied.LastUsedAt = copyTime(key.LastUsedAt)
+			keys = append(keys, copied)
+		}
+	}
+	slices.SortFunc(keys, func(a, b model.APIKey) int {
+		return a.ID - b.ID
+	})
+	return keys, nil
+}
+
+func (r *MemRepo) RevokeAPIKey(userID int, keyID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.apiKeys[keyID]
+	if !ok || key.userID != userID || key.revoked {
+		return errs.ErrAPIKeyNotFound
+	}
+	key.revoked = true
+	return nil
+}
+
+func (r *MemRepo) AuthenticateAPIKey(keyHash string) (int, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.apiKeys {
+		if key.hash != keyHash || key.revoked {
+			continue
+		}
+		user, ok := r.activeUser(key.userID)
+		if !ok || user.blockedAt != nil {
+			break
+		}
+		now := time.Now()
+		if key.LastUsedAt == nil || key.LastUsedAt.Before(now.Add(-time.Minute)) {
+			key.LastUsedAt = &now
+		}
+		return key.userID, slices.Clone(key.Scopes), nil
+	}
+	return 0, nil, errs.ErrAPIKeyNotFound
+}
+
+func (r *MemRepo) CreatePartner(partner model.Partner) (model.Partner, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.partners {
+		if existing.KeyID == partner.KeyID {
+			return model.Partner{}, fmt.Errorf("partner key %s already exists", partner.KeyID)
+		}
+	}
+	partner.ID = r.nextID("partners")
+	partner.CreatedAt = time.Now()
+	stored := partner
+	r.partners[partner.ID] = &stored
+	return partner, nil
+}
+
+func (r *MemRepo) GetPartners() ([]model.Partner, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	partners := make([]model.Partner, 0)
+	for _, partner := range r.partners {
+		if !r.revokedPartners[partner.ID] {
+			copied := *partner
+			copied.Secret = ""
+			partners = append(partners, copied)
+		}
+	}
+	slices.SortFunc(partners, func(a, b model.Partner) int {
+		return a.ID - b.ID
+	})
+	return partners, nil
+}
+
+func (r *MemRepo) RevokePartner(partnerID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.partners[partnerID]; !ok || r.revokedPartners[partnerID] {
+		return errs.ErrPartnerNotFound
+	}
+	r.revokedPartners[partnerID] = true
+	return nil
+}
+
+func (r *MemRepo) GetPartnerSecret(keyID string) (int, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, partner := range r.partners {
+		if partner.KeyID == keyID && !r.revokedPartners[partner.ID] {
+			return partner.ID, partner.Secret, nil
+		}
+	}
+	return 0, "", errs.ErrPartnerNotFound
+}
+
+func (r *MemRepo) UsePartnerNonce(partnerID int, nonce string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, expiresAt := range r.partnerNonces {
+		if key.partnerID == partnerID && expiresAt.Before(now) {
+			delete(r.partnerNonces, key)
+		}
+	}
+	key := memPartnerNonce{partnerID: partnerID, nonce: nonce}
+	if _, ok := r.partnerNonces[key]; ok {
+		return false, nil
+	}
+	r.partnerNonces[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (r *MemRepo) LinkPartnerCustomer(partnerID int, externalID string, login string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.activeUser(r.logins[login])
+	if !ok {
+		return errs.ErrCustomerNotFound
+	}
+	r.partnerCustomers[memPartnerCustomer{partnerID: partnerID, externalID: externalID}] = user.id
+	return nil
+}
+
+func (r *MemRepo) GetPartnerCustomer(partnerID int, customer model.PartnerOrder) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var userID int
+	if customer.ExternalID != "" {
+		userID = r.partnerCustomers[memPartnerCustomer{partnerID: partnerID, externalID: customer.ExternalID}]
+	} else {
+		userID = r.logins[customer.Login]
+	}
+	user, ok := r.activeUser(userID)
+	if !ok || user.blockedAt != nil {
+		return 0, errs.ErrCustomerNotFound
+	}
+	return user.id, nil
+}
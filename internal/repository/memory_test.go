@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+// TestRoundAmount checks that amounts are rounded like Postgres rounds them into numeric(8, 2):
+// from their shortest decimal representation, half away from zero.
+func TestRoundAmount(t *testing.T) {
+	tests := []struct {
+		amount  float64
+		cents   int64
+		rounded float64
+		valid   bool
+	}{
+		{0, 0, 0, true},
+		{0.1 + 0.2, 30, 0.3, true},
+		{1.005, 101, 1.01, true},
+		{1.015, 102, 1.02, true},
+		{10.004, 1000, 10, true},
+		{-10.005, -1001, -10.01, true},
+		{999999.994, 99999999, 999999.99, true},
+		{999999.995, 100000000, 0, false},
+		{-999999.995, -100000000, 0, false},
+		{1e20, math.MaxInt64, 0, false},
+		{-1e20, -math.MaxInt64, 0, false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.cents, cents(tt.amount), "%v", tt.amount)
+		rounded, err := roundAmount(tt.amount)
+		if !tt.valid {
+			require.Error(t, err, "%v", tt.amount)
+			continue
+		}
+		require.NoError(t, err, "%v", tt.amount)
+		require.Equal(t, tt.rounded, rounded, "%v", tt.amount)
+	}
+}
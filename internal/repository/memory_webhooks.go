@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"slices"
+	"time"
+)
+
+type memWebhook struct {
+	model.Webhook
+	userID  int
+	deleted bool
+}
+
+func (r *MemRepo) CreateWebhook(userID int, url string, secret string, events []string) (model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook := model.Webhook{
+		ID:        r.nextID("webhooks"),
+		URL:       url,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	stored := webhook
+	stored.Events = slices.Clone(events)
+	r.webhooks[webhook.ID] = &memWebhook{Webhook: stored, userID: userID}
+	return webhook, nil
+}
+
+func (r *MemRepo) GetWebhooks(userID int) ([]model.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhooks := make([]model.Webhook, 0)
+	for _, webhook := range r.userWebhooks(userID) {
+		if !webhook.deleted {
+			webhooks = append(webhooks, model.Webhook{
+				ID:        webhook.ID,
+				URL:       webhook.URL,
+				Events:    slices.Clone(webhook.Events),
+				CreatedAt: webhook.CreatedAt,
+			})
+		}
+	}
+	return webhooks, nil
+}
+
+// userWebhooks returns all the webhooks of the user, including deleted ones, ordered by ID.
+func (r *MemRepo) userWebhooks(userID int) []*memWebhook {
+	var webhooks []*memWebhook
+	for _, webhook := range r.webhooks {
+		if webhook.userID == userID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b *memWebhook) int {
+		return a.ID - b.ID
+	})
+	return webhooks
+}
+
+func (r *MemRepo) DeleteWebhook(userID int, webhookID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook, ok := r.webhooks[webhookID]
+	if !ok || webhook.userID != userID || webhook.deleted {
+		return errs.ErrWebhookNotFound
+	}
+	webhook.deleted = true
+	for _, delivery := range r.deliveries {
+		if delivery.WebhookID == webhookID && delivery.Status == "PENDING" {
+			delivery.Status = "CANCELLED"
+		}
+	}
+	return nil
+}
+
+func (r *MemRepo) GetWebhookDeliveries(userID int, webhookID int) ([]model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook, ok := r.webhooks[webhookID]
+	if !ok || webhook.userID != userID {
+		return nil, errs.ErrWebhookNotFound
+	}
+
+	deliveries := make([]model.WebhookDelivery, 0)
+	for i := len(r.deliveries) - 1; i >= 0 && len(deliveries) < maxListedDeliveries; i-- {
+		if r.deliveries[i].WebhookID == webhookID {
+			delivery := *r.deliveries[i]
+			delivery.Payload = slices.Clone(delivery.Payload)
+			delivery.DeliveredAt = copyTime(delivery.DeliveredAt)
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (r *MemRepo) EnqueueWebhookEvent(userID int, event string, payload []byte) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.enqueueWebhookEvent(userID, event, payload)
+}
+
+func (r *MemRepo) enqueueWebhookEvent(userID int, event string, payload []byte) (int64, error) {
+	if !json.Valid(payload) {
+		return 0, fmt.Errorf("invalid webhook payload: %s", payload)
+	}
+	now := time.Now()
+	var enqueued int64
+	for _, webhook := range r.userWebhooks(userID) {
+		if webhook.deleted || !slices.Contains(webhook.Events, event) {
+			continue
+		}
+		r.deliveries = append(r.deliveries, &model.WebhookDelivery{
+			ID:            int64(r.nextID("webhook_deliveries")),
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       slices.Clone(payload),
+			Status:        "PENDING",
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		enqueued++
+	}
+	return enqueued, nil
+}
+
+func (r *MemRepo) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var due []*model.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.Status == "PENDING" && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	slices.SortStableFunc(due, func(a, b *model.WebhookDelivery) int {
+		return a.NextAttemptAt.Compare(b.NextAttemptAt)
+	})
+
+	var deliveries []model.WebhookDelivery
+	for _, delivery := range due[:min(len(due), limit)] {
+		delivery.NextAttemptAt = now.Add(lease)
+		webhook := r.webhooks[delivery.WebhookID]
+		deliveries = append(deliveries, model.WebhookDelivery{
+			ID:        delivery.ID,
+			WebhookID: delivery.WebhookID,
+			Event:     delivery.Event,
+			Payload:   slices.Clone(delivery.Payload),
+			Attempts:  delivery.Attempts,
+			CreatedAt: delivery.CreatedAt,
+			URL:       webhook.URL,
+			Secret:    webhook.Secret,
+		})
+	}
+	return deliveries, nil
+}
+
+func (r *MemRepo) RecordWebhookAttempt(delivery model.WebhookDelivery, retryIn time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.deliveries, func(stored *model.WebhookDelivery) bool {
+		return stored.ID == delivery.ID
+	})
+	if i < 0 {
+		return nil
+	}
+	now := time.Now()
+	stored := r.deliveries[i]
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttemptAt = now.Add(retryIn)
+	stored.ResponseCode = delivery.ResponseCode
+	stored.LastError = delivery.LastError
+	stored.DeliveredAt = nil
+	if delivery.Status == "DELIVERED" {
+		stored.DeliveredAt = &now
+	}
+	return nil
+}
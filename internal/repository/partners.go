@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"time"
+)
+
+func (r *Repo) CreatePartner(partner model.Partner) (model.Partner, error) {
+	query := "INSERT INTO partners (name, key_id, secret) VALUES ($1, $2, $3) RETURNING id, created_at"
+	err := r.db.QueryRow(query, partner.Name, partner.KeyID, partner.Secret).Scan(&partner.ID, &partner.CreatedAt)
+	if err != nil {
+		return model.Partner{}, err
+	}
+	return partner, nil
+}
+
+func (r *Repo) GetPartners() ([]model.Partner, error) {
+	query := "SELECT id, name, key_id, created_at FROM partners WHERE revoked_at IS NULL ORDER BY id"
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partners := make([]model.Partner, 0)
+	for rows.Next() {
+		var partner model.Partner
+		if err = rows.Scan(&partner.ID, &partner.Name, &partner.KeyID, &partner.CreatedAt); err != nil {
+			return nil, err
+		}
+		partners = append(partners, partner)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return partners, nil
+}
+
+func (r *Repo) RevokePartner(partnerID int) error {
+	res, err := r.db.Exec("UPDATE partners SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", partnerID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return errs.ErrPartnerNotFound
+	}
+	return nil
+}
+
+// GetPartnerSecret returns the ID and signing secret of an active partner by its key ID.
+func (r *Repo) GetPartnerSecret(keyID string) (int, string, error) {
+	var partnerID int
+	var secret string
+	query := "SELECT id, secret FROM partners WHERE key_id = $1 AND revoked_at IS NULL"
+	err := r.db.QueryRow(query, keyID).Scan(&partnerID, &secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errs.ErrPartnerNotFound
+	}
+	return partnerID, secret, err
+}
+
+// UsePartnerNonce remembers the nonce of a partner request for the ttl and reports whether it
+// wasn't used before. The expired nonces of the partner are forgotten along the way.
+func (r *Repo) UsePartnerNonce(partnerID int, nonce string, ttl time.Duration) (bool, error) {
+	if _, err := r.db.Exec("DELETE FROM partner_nonces WHERE partner_id = $1 AND expires_at < now()", partnerID); err != nil {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO partner_nonces (partner_id, nonce, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (partner_id, nonce) DO NOTHING`
+	res, err := r.db.Exec(query, partnerID, nonce, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	return inserted > 0, err
+}
+
+// LinkPartnerCustomer maps the partner's external customer ID to the user with the login.
+// An existing mapping of the external ID is replaced.
+func (r *Repo) LinkPartnerCustomer(partnerID int, externalID string, login string) error {
+	query := `
+		INSERT INTO partner_customers (partner_id, external_id, user_id)
+		SELECT $1, $2, id FROM users WHERE login = $3 AND deleted_at IS NULL
+		ON CONFLICT (partner_id, external_id) DO UPDATE SET user_id = excluded.user_id, created_at = now()`
+	res, err := r.db.Exec(query, partnerID, externalID, login)
+	if err != nil {
+		return err
+	}
+	linked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if linked == 0 {
+		return errs.ErrCustomerNotFound
+	}
+	return nil
+}
+
+// GetPartnerCustomer returns the user by login or by the partner's external ID.
+func (r *Repo) GetPartnerCustomer(partnerID int, customer model.PartnerOrder) (int, error) {
+	var userID int
+	var err error
+	if customer.ExternalID != "" {
+		query := `
+			SELECT u.id FROM partner_customers pc JOIN users u ON u.id = pc.user_id
+			WHERE pc.partner_id = $1 AND pc.external_id = $2 AND u.deleted_at IS NULL AND u.blocked_at IS NULL`
+		err = r.db.QueryRow(query, partnerID, customer.ExternalID).Scan(&userID)
+	} else {
+		query := "SELECT id FROM users WHERE login = $1 AND deleted_at IS NULL AND blocked_at IS NULL"
+		err = r.db.QueryRow(query, customer.Login).Scan(&userID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errs.ErrCustomerNotFound
+	}
+	return userID, err
+}
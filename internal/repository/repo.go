@@ -6,31 +6,28 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/kuznet1/gophermart/internal/errs"
 	"github.com/kuznet1/gophermart/internal/model"
-	"golang.org/x/crypto/bcrypt"
 )
 
-const bcryptCost = 14
+const maxReplayedEvents = 1000
 
 type Repo struct {
-	db *sql.DB
+	db    *sql.DB
+	types *pgtype.Map
 }
 
 func NewRepo(db *sql.DB) *Repo {
 	return &Repo{
-		db: db,
+		db:    db,
+		types: pgtype.NewMap(),
 	}
 }
 
-func (r *Repo) Register(user model.UserCredentials) (int, error) {
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcryptCost)
-	if err != nil {
-		return 0, err
-	}
+func (r *Repo) Register(login string, passwordHash string) (int, error) {
 	var userID int
-	err = r.db.QueryRow("INSERT INTO users (login, password) VALUES ($1, $2) RETURNING id", user.Login, passwordHash).Scan(&userID)
+	err := r.db.QueryRow("INSERT INTO users (login, password) VALUES ($1, $2) RETURNING id", login, passwordHash).Scan(&userID)
 	var e *pgconn.PgError
 	if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
 		return 0, errs.ErrUserExists
@@ -41,22 +38,29 @@ func (r *Repo) Register(user model.UserCredentials) (int, error) {
 	return userID, nil
 }
 
-func (r *Repo) Login(user model.UserCredentials) (int, error) {
+func (r *Repo) GetUserCredentials(login string) (int, string, error) {
 	var userID int
 	var passHash string
-	row := r.db.QueryRow("SELECT id, password  FROM users WHERE login = $1", user.Login)
-	if err := row.Scan(&userID, &passHash); err != nil {
-		return 0, errs.ErrUserCredentials
+	row := r.db.QueryRow("SELECT id, password FROM users WHERE login = $1 AND deleted_at IS NULL", login)
+	err := row.Scan(&userID, &passHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errs.ErrUserCredentials
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(passHash), []byte(user.Password)); err != nil {
-		return 0, errs.ErrUserCredentials
+	if err != nil {
+		return 0, "", err
 	}
-	return userID, nil
+	return userID, passHash, nil
+}
+
+func (r *Repo) UpdatePasswordHash(userID int, passwordHash string) error {
+	_, err := r.db.Exec("UPDATE users SET password = $2 WHERE id = $1", userID, passwordHash)
+	return err
 }
 
-func (r *Repo) AddOrder(userID int, orderNum int) error {
-	query := "INSERT INTO orders (order_id, user_id) VALUES ($1, $2)"
-	_, err := r.db.Exec(query, orderNum, userID)
+// AddOrder stores the order of the user. partnerID is the partner who submitted the order, or 0.
+func (r *Repo) AddOrder(userID int, orderNum string, partnerID int) error {
+	query := "INSERT INTO orders (order_id, user_id, partner_id) VALUES ($1, $2, nullif($3, 0))"
+	_, err := r.db.Exec(query, orderNum, userID, partnerID)
 	var e *pgconn.PgError
 	if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
 		var id int
@@ -64,15 +68,100 @@ func (r *Repo) AddOrder(userID int, orderNum int) error {
 		if err = r.db.QueryRow(query, orderNum).Scan(&id); err != nil {
 			return err
 		}
-		if userID != id {
-			return errs.ErrOrderUploadedByOtherUser
-		} else {
-			return errs.ErrOrderUploadedByUser
-		}
+		return orderOwnerErr(userID, id)
 	}
 	return err
 }
 
+func (r *Repo) AddOrders(userID int, orderNums []string) (map[string]error, error) {
+	query := `
+		WITH input AS (
+			SELECT DISTINCT unnest($1::text[]) AS order_id
+		), inserted AS (
+			INSERT INTO orders (order_id, user_id)
+			SELECT order_id, $2 FROM input
+			ON CONFLICT (order_id) DO NOTHING
+			RETURNING order_id
+		)
+		SELECT input.order_id, inserted.order_id IS NOT NULL, orders.user_id
+		FROM input
+		LEFT JOIN inserted ON inserted.order_id = input.order_id
+		LEFT JOIN orders ON orders.order_id = input.order_id`
+	rows, err := r.db.Query(query, orderNums, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[string]error, len(orderNums))
+	var unknownOwner []string
+	for rows.Next() {
+		var order string
+		var inserted bool
+		var ownerID sql.NullInt64
+		if err = rows.Scan(&order, &inserted, &ownerID); err != nil {
+			return nil, err
+		}
+		switch {
+		case inserted:
+			results[order] = nil
+		case !ownerID.Valid:
+			// inserted concurrently by another transaction, not visible in our snapshot
+			unknownOwner = append(unknownOwner, order)
+		default:
+			results[order] = orderOwnerErr(userID, int(ownerID.Int64))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, order := range unknownOwner {
+		var ownerID int
+		query = "SELECT user_id FROM orders WHERE order_id = $1"
+		if err = r.db.QueryRow(query, order).Scan(&ownerID); err != nil {
+			return nil, err
+		}
+		results[order] = orderOwnerErr(userID, ownerID)
+	}
+	return results, nil
+}
+
+// CancelOrder deletes a NEW or INVALID order of the user, which also takes it out of
+// the accrual queue, and records the cancellation in order_audit.
+func (r *Repo) CancelOrder(userID int, orderNum string) error {
+	query := `
+		WITH cancelled AS (
+			DELETE FROM orders
+			WHERE order_id = $1 AND user_id = $2 AND status IN ('NEW', 'INVALID')
+			RETURNING order_id, user_id, status, uploaded_at
+		)
+		INSERT INTO order_audit (order_id, user_id, action, status, uploaded_at)
+		SELECT order_id, user_id, 'CANCELLED', status, uploaded_at FROM cancelled`
+	res, err := r.db.Exec(query, orderNum, userID)
+	if err != nil {
+		return err
+	}
+	cancelled, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if cancelled > 0 {
+		return nil
+	}
+
+	var ownerID int
+	query = "SELECT user_id FROM orders WHERE order_id = $1"
+	err = r.db.QueryRow(query, orderNum).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && ownerID != userID) {
+		return errs.ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return errs.ErrOrderNotCancellable
+}
+
 func (r *Repo) GetOrders(userID int) ([]model.Order, error) {
 	query := "SELECT order_id, status, accrual, uploaded_at FROM orders WHERE user_id = $1  ORDER BY uploaded_at DESC"
 	rows, err := r.db.Query(query, userID)
@@ -97,37 +186,53 @@ func (r *Repo) GetOrders(userID int) ([]model.Order, error) {
 	return orders, nil
 }
 
-func (r *Repo) NewWithdrawal(userID int, withdraws model.Withdraw) error {
+// execer runs statements either directly on the database or in a transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// NewWithdrawal records the withdrawal if the balance covers it. The user row is locked first,
+// so that concurrent withdrawals of the user check the balance one after another. A non-nil
+// totpCounter is the time step of the two-factor code authorizing the withdrawal; it is used up
+// only along with a withdrawal that succeeds. A non-nil event is enqueued for the user's webhooks
+// in the same transaction, so that it's never lost once the withdrawal is committed.
+func (r *Repo) NewWithdrawal(userID int, withdraws model.Withdraw, totpCounter *int64, event *model.WebhookEvent) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	done := false
-	defer func() {
-		if done {
-			tx.Commit()
-		} else {
-			tx.Rollback()
-		}
-	}()
-
+	if _, err = tx.Exec("SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return err
+	}
 	balance, err := r.doGetBalance(tx, userID)
 	if err != nil {
 		return err
 	}
-
 	if withdraws.Sum > balance.Current {
 		return errs.ErrBalanceNotEnoughPoints
 	}
+	if totpCounter != nil {
+		fresh, err := useTOTPCounter(tx, userID, *totpCounter)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errs.ErrInvalidTOTPCode
+		}
+	}
 
 	query := "INSERT INTO withdrawals (user_id, order_id, sum) VALUES ($1, $2, $3)"
-	_, err = r.db.Exec(query, userID, withdraws.Order, withdraws.Sum)
-	if err != nil {
+	if _, err = tx.Exec(query, userID, withdraws.Order, withdraws.Sum); err != nil {
 		return err
 	}
-	done = true
-	return nil
+	if event != nil {
+		if _, err = enqueueWebhookEvent(tx, userID, event.Event, event.Payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (r *Repo) GetWithdrawals(userID int) ([]model.Withdrawal, error) {
@@ -178,25 +283,76 @@ func (r *Repo) GetBalance(userID int) (model.Balance, error) {
 	return balance, nil
 }
 
-func (r *Repo) UpdateAccrual(accrual model.AccrualResp) error {
-	_, err := r.db.Exec(
-		"UPDATE orders SET status=$1, accrual=$2 WHERE order_id = $3",
-		accrual.Status, accrual.Accrual, accrual.Order,
+// GetDataVersion returns the counter which is bumped on every change of the user's
+// orders and withdrawals.
+func (r *Repo) GetDataVersion(userID int) (int64, error) {
+	var version int64
+	err := r.db.QueryRow("SELECT data_version FROM users WHERE id = $1", userID).Scan(&version)
+	return version, err
+}
+
+// UpdateAccrual stores the accrual system response and, if the order status or
+// accrual has actually changed, records and returns the corresponding order event.
+func (r *Repo) UpdateAccrual(accrual model.AccrualResp) (*model.OrderEvent, error) {
+	query := `
+		WITH updated AS (
+			UPDATE orders SET status = $1, accrual = $2
+			WHERE order_id = $3 AND (status <> $1 OR accrual IS DISTINCT FROM $2)
+			RETURNING user_id, order_id, status, accrual
+		)
+		INSERT INTO order_events (user_id, order_id, status, accrual)
+		SELECT user_id, order_id, status, accrual FROM updated
+		RETURNING id, user_id, order_id, status, accrual, created_at`
+	var event model.OrderEvent
+	err := r.db.QueryRow(query, accrual.Status, accrual.Accrual, accrual.Order).Scan(
+		&event.ID, &event.UserID, &event.Order, &event.Status, &event.Accrual, &event.ChangedAt,
 	)
-	return err
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (r *Repo) GetOrderEvents(userID int, afterID int64) ([]model.OrderEvent, error) {
+	query := `
+		SELECT id, user_id, order_id, status, accrual, created_at FROM order_events
+		WHERE user_id = $1 AND id > $2 ORDER BY id LIMIT $3`
+	rows, err := r.db.Query(query, userID, afterID, maxReplayedEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]model.OrderEvent, 0)
+	for rows.Next() {
+		var event model.OrderEvent
+		err = rows.Scan(&event.ID, &event.UserID, &event.Order, &event.Status, &event.Accrual, &event.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
 }
 
-func (r *Repo) GetProcessingOrders() ([]int, error) {
+func (r *Repo) GetProcessingOrders() ([]string, error) {
 	query := "SELECT order_id FROM orders WHERE status IN ('NEW', 'PROCESSING')"
 	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var orders []int
+	var orders []string
 
 	for rows.Next() {
-		var order int
+		var order string
 		err = rows.Scan(&order)
 		if err != nil {
 			return nil, err
@@ -223,8 +379,21 @@ func (r *Repo) doGetBalance(tx *sql.Tx, userID int) (model.Balance, error) {
 		return model.Balance{}, err
 	}
 
+	row = tx.QueryRow("SELECT coalesce(SUM(amount), 0) FROM balance_adjustments WHERE user_id = $1 AND status = 'APPLIED'", userID)
+	var sumAdjustments float64
+	if err := row.Scan(&sumAdjustments); err != nil {
+		return model.Balance{}, err
+	}
+
 	return model.Balance{
-		Current:   sumAccruals - sumWithdrawals,
+		Current:   sumAccruals + sumAdjustments - sumWithdrawals,
 		Withdrawn: sumWithdrawals,
 	}, nil
 }
+
+func orderOwnerErr(userID int, ownerID int) error {
+	if userID != ownerID {
+		return errs.ErrOrderUploadedByOtherUser
+	}
+	return errs.ErrOrderUploadedByUser
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"time"
+)
+
+func (r *Repo) CreateSession(userID int, sessionID string, refreshHash string, ttl time.Duration, client model.ClientInfo) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO sessions (id, user_id, expires_at, user_agent, ip)
+		VALUES ($1, $2, now() + make_interval(secs => $3), $4, $5)`
+	if _, err = tx.Exec(query, sessionID, userID, ttl.Seconds(), client.UserAgent, client.IP); err != nil {
+		return err
+	}
+
+	query = "INSERT INTO refresh_tokens (token_hash, session_id) VALUES ($1, $2)"
+	if _, err = tx.Exec(query, refreshHash, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one within the same session
+// and extends the session. Presenting an already used refresh token means it has leaked,
+// so the whole session is revoked.
+func (r *Repo) RotateRefreshToken(oldHash string, newHash string, ttl time.Duration) (int, string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT s.id, s.user_id, rt.used_at IS NOT NULL, s.revoked_at IS NULL AND s.expires_at > now()
+		FROM refresh_tokens rt JOIN sessions s ON s.id = rt.session_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE`
+	var sessionID string
+	var userID int
+	var used, active bool
+	err = tx.QueryRow(query, oldHash).Scan(&sessionID, &userID, &used, &active)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", errs.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if used {
+		query = "UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL"
+		if _, err = tx.Exec(query, sessionID); err != nil {
+			return 0, "", err
+		}
+		if err = tx.Commit(); err != nil {
+			return 0, "", err
+		}
+		return 0, "", errs.ErrInvalidRefreshToken
+	}
+	if !active {
+		return 0, "", errs.ErrInvalidRefreshToken
+	}
+
+	if _, err = tx.Exec("UPDATE refresh_tokens SET used_at = now() WHERE token_hash = $1", oldHash); err != nil {
+		return 0, "", err
+	}
+	query = "INSERT INTO refresh_tokens (token_hash, session_id) VALUES ($1, $2)"
+	if _, err = tx.Exec(query, newHash, sessionID); err != nil {
+		return 0, "", err
+	}
+	query = "UPDATE sessions SET expires_at = now() + make_interval(secs => $2), last_seen_at = now() WHERE id = $1"
+	if _, err = tx.Exec(query, sessionID, ttl.Seconds()); err != nil {
+		return 0, "", err
+	}
+	return userID, sessionID, tx.Commit()
+}
+
+func (r *Repo) RevokeSession(userID int, sessionID string) error {
+	query := `
+		UPDATE sessions SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()`
+	res, err := r.db.Exec(query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return errs.ErrSessionNotFound
+	}
+	return nil
+}
+
+// IsSessionActive reports whether the session is neither revoked nor expired. The last seen
+// time of an active session is updated at most once a minute.
+func (r *Repo) IsSessionActive(sessionID string) (bool, error) {
+	var active, stale bool
+	query := `
+		SELECT revoked_at IS NULL AND expires_at > now(), last_seen_at < now() - interval '1 minute'
+		FROM sessions WHERE id = $1`
+	err := r.db.QueryRow(query, sessionID).Scan(&active, &stale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if active && stale {
+		if _, err = r.db.Exec("UPDATE sessions SET last_seen_at = now() WHERE id = $1", sessionID); err != nil {
+			return false, err
+		}
+	}
+	return active, nil
+}
+
+// GetSessions returns the active sessions of the user, most recently used first.
+func (r *Repo) GetSessions(userID int) ([]model.Session, error) {
+	query := `
+		SELECT id, user_agent, ip, created_at, last_seen_at, expires_at FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY last_seen_at DESC`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]model.Session, 0)
+	for rows.Next() {
+		var session model.Session
+		err = rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"github.com/kuznet1/gophermart/internal/model"
+	"time"
+)
+
+// UserStorage keeps user accounts, their credentials, roles and two-factor settings.
+type UserStorage interface {
+	Register(login string, passwordHash string) (int, error)
+	GetUserCredentials(login string) (int, string, error)
+	GetUserCredentialsByID(userID int) (string, string, error)
+	UpdatePasswordHash(userID int, passwordHash string) error
+	SetPassword(userID int, passwordHash string, keepSessionID string) error
+	CreatePasswordReset(userID int, tokenHash string, ttl time.Duration) error
+	GetPasswordResetUser(tokenHash string) (int, error)
+	ResetPassword(tokenHash string, passwordHash string) error
+	DeleteUser(userID int, retention string) error
+	GetUserAccess(userID int) (string, bool, error)
+	SyncAdmins(logins []string) (int64, int64, error)
+	SetUserBlocked(userID int, blocked bool) error
+	SearchUsers(login string) ([]model.AdminUser, error)
+	GetDataVersion(userID int) (int64, error)
+
+	GetTOTP(userID int) (string, bool, error)
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, counter int64, recoveryHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPCounter(userID int, counter int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	UseChallenge(challengeID string, ttl time.Duration) (bool, error)
+}
+
+// SessionStorage keeps login sessions, their refresh tokens and failed login counters.
+type SessionStorage interface {
+	CreateSession(userID int, sessionID string, refreshHash string, ttl time.Duration, client model.ClientInfo) error
+	RotateRefreshToken(oldHash string, newHash string, ttl time.Duration) (int, string, error)
+	RevokeSession(userID int, sessionID string) error
+	IsSessionActive(sessionID string) (bool, error)
+	GetSessions(userID int) ([]model.Session, error)
+
+	GetLoginLock(keys []string) (time.Duration, error)
+	RecordLoginFailure(key string, window time.Duration) (int, error)
+	LockLogin(key string, duration time.Duration) error
+	ResetLoginFailures(keys []string) (int64, error)
+}
+
+// OrderStorage keeps uploaded orders and the history of their status changes.
+type OrderStorage interface {
+	AddOrder(userID int, orderNum string, partnerID int) error
+	AddOrders(userID int, orderNums []string) (map[string]error, error)
+	CancelOrder(userID int, orderNum string) error
+	GetOrders(userID int) ([]model.Order, error)
+	GetOrderEvents(userID int, afterID int64) ([]model.OrderEvent, error)
+	SearchOrders(filter model.AdminOrderFilter) ([]model.AdminOrder, error)
+	RequeueOrder(order string) (*model.OrderEvent, error)
+}
+
+// WithdrawalStorage keeps withdrawals and balance adjustments, which together with
+// the accruals of the orders make up the user's balance.
+type WithdrawalStorage interface {
+	NewWithdrawal(userID int, withdraws model.Withdraw, totpCounter *int64, event *model.WebhookEvent) error
+	GetWithdrawals(userID int) ([]model.Withdrawal, error)
+	GetBalance(userID int) (model.Balance, error)
+
+	CreateAdjustment(adjustment model.BalanceAdjustment) (model.BalanceAdjustment, error)
+	GetAdjustments(status string) ([]model.BalanceAdjustment, error)
+	GetUserAdjustments(userID int) ([]model.BalanceAdjustment, error)
+	ReviewAdjustment(adjustmentID int, reviewerID int, approve bool) (model.BalanceAdjustment, error)
+}
+
+// AccrualQueue gives the accrual poller the orders awaiting accrual and takes the results.
+type AccrualQueue interface {
+	GetProcessingOrders() ([]string, error)
+	UpdateAccrual(accrual model.AccrualResp) (*model.OrderEvent, error)
+}
+
+// WebhookStorage keeps webhooks and the outbox of their deliveries.
+type WebhookStorage interface {
+	CreateWebhook(userID int, url string, secret string, events []string) (model.Webhook, error)
+	GetWebhooks(userID int) ([]model.Webhook, error)
+	DeleteWebhook(userID int, webhookID int) error
+	GetWebhookDeliveries(userID int, webhookID int) ([]model.WebhookDelivery, error)
+	EnqueueWebhookEvent(userID int, event string, payload []byte) (int64, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	RecordWebhookAttempt(delivery model.WebhookDelivery, retryIn time.Duration) error
+}
+
+// CredentialStorage keeps API keys and partners allowed to call the API.
+type CredentialStorage interface {
+	CreateAPIKey(userID int, key model.APIKey, keyHash string) (model.APIKey, error)
+	GetAPIKeys(userID int) ([]model.APIKey, error)
+	RevokeAPIKey(userID int, keyID int) error
+	AuthenticateAPIKey(keyHash string) (int, []string, error)
+
+	CreatePartner(partner model.Partner) (model.Partner, error)
+	GetPartners() ([]model.Partner, error)
+	RevokePartner(partnerID int) error
+	GetPartnerSecret(keyID string) (int, string, error)
+	UsePartnerNonce(partnerID int, nonce string, ttl time.Duration) (bool, error)
+	LinkPartnerCustomer(partnerID int, externalID string, login string) error
+	GetPartnerCustomer(partnerID int, customer model.PartnerOrder) (int, error)
+}
+
+// Storage is everything the service keeps. It is implemented by Repo on top of
+// Postgres and by MemRepo in memory.
+type Storage interface {
+	UserStorage
+	SessionStorage
+	OrderStorage
+	WithdrawalStorage
+	AccrualQueue
+	WebhookStorage
+	CredentialStorage
+	AddAdminAudit(adminID int, action string, target string, details []byte) error
+}
+
+var (
+	_ Storage = (*Repo)(nil)
+	_ Storage = (*MemRepo)(nil)
+)
@@ -0,0 +1,27 @@
+package repository_test
+
+import (
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/kuznet1/gophermart/internal/repository/storagetest"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestMemRepo(t *testing.T) {
+	storagetest.Run(t, repository.NewMemRepo())
+}
+
+// TestRepo runs the conformance suite against Postgres at TEST_DATABASE_URI, if it's set.
+func TestRepo(t *testing.T) {
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+	db, closeDB, err := repository.InitDBConnection(config.Config{DatabaseURI: uri, MigrationsPath: "file://../../migrations", DBConnectAttempts: 1})
+	require.NoError(t, err)
+	defer closeDB()
+
+	storagetest.Run(t, repository.NewRepo(db))
+}
@@ -0,0 +1,608 @@
+// Package storagetest checks that a repository.Storage implementation behaves like the Postgres Repo.
+//
+// The suite only creates users and orders unique to the run, so it can be pointed at a
+// database that already has data in it.
+package storagetest
+
+import (
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tick separates records whose order depends on their timestamps. Postgres timestamps
+// have microsecond precision, so records created right after each other could tie.
+const tick = 2 * time.Millisecond
+
+var (
+	runID = time.Now().UnixNano()
+	seq   atomic.Int64
+)
+
+// Run runs the conformance suite against the storage.
+func Run(t *testing.T, storage repository.Storage) {
+	t.Run("users", func(t *testing.T) { testUsers(t, storage) })
+	t.Run("deleted users", func(t *testing.T) { testDeletedUsers(t, storage) })
+	t.Run("duplicate orders", func(t *testing.T) { testDuplicateOrders(t, storage) })
+	t.Run("orders", func(t *testing.T) { testOrders(t, storage) })
+	t.Run("processing orders", func(t *testing.T) { testProcessingOrders(t, storage) })
+	t.Run("order events", func(t *testing.T) { testOrderEvents(t, storage) })
+	t.Run("balance", func(t *testing.T) { testBalance(t, storage) })
+	t.Run("withdrawals", func(t *testing.T) { testWithdrawals(t, storage) })
+	t.Run("adjustments", func(t *testing.T) { testAdjustments(t, storage) })
+	t.Run("sessions", func(t *testing.T) { testSessions(t, storage) })
+	t.Run("challenges", func(t *testing.T) { testChallenges(t, storage) })
+	t.Run("admins", func(t *testing.T) { testAdmins(t, storage) })
+	t.Run("partner nonces", func(t *testing.T) { testPartnerNonces(t, storage) })
+}
+
+func newUser(t *testing.T, storage repository.Storage) int {
+	t.Helper()
+	userID, err := storage.Register(newLogin(), "hash")
+	require.NoError(t, err)
+	return userID
+}
+
+func newLogin() string {
+	return fmt.Sprintf("storagetest-%d-%d", runID, seq.Add(1))
+}
+
+func newOrder() string {
+	return fmt.Sprintf("%d%06d", runID, seq.Add(1))
+}
+
+func addOrder(t *testing.T, storage repository.Storage, userID int, status string, accrual float64) string {
+	t.Helper()
+	order := newOrder()
+	require.NoError(t, storage.AddOrder(userID, order, 0))
+	if status != "NEW" {
+		_, err := storage.UpdateAccrual(model.AccrualResp{Order: order, Status: status, Accrual: accrual})
+		require.NoError(t, err)
+	}
+	return order
+}
+
+func testUsers(t *testing.T, storage repository.Storage) {
+	login := newLogin()
+	userID, err := storage.Register(login, "hash")
+	require.NoError(t, err)
+
+	_, err = storage.Register(login, "other")
+	require.ErrorIs(t, err, errs.ErrUserExists)
+
+	id, hash, err := storage.GetUserCredentials(login)
+	require.NoError(t, err)
+	require.Equal(t, userID, id)
+	require.Equal(t, "hash", hash)
+
+	_, _, err = storage.GetUserCredentials(newLogin())
+	require.ErrorIs(t, err, errs.ErrUserCredentials)
+
+	require.NoError(t, storage.DeleteUser(userID, "anonymize"))
+	_, _, err = storage.GetUserCredentials(login)
+	require.ErrorIs(t, err, errs.ErrUserCredentials)
+	_, _, err = storage.GetUserAccess(userID)
+	require.ErrorIs(t, err, errs.ErrUserNotFound)
+}
+
+func testDeletedUsers(t *testing.T, storage repository.Storage) {
+	anonymized := func(userID int) []model.AdminUser {
+		t.Helper()
+		users, err := storage.SearchUsers(model.AnonymizedLoginPrefix + strconv.Itoa(userID))
+		require.NoError(t, err)
+		return slices.DeleteFunc(users, func(user model.AdminUser) bool { return user.ID != userID })
+	}
+
+	for _, retention := range []string{"anonymize", "delete"} {
+		userID := newUser(t, storage)
+		order := addOrder(t, storage, userID, "PROCESSED", 10)
+		require.NoError(t, storage.DeleteUser(userID, retention))
+		require.ErrorIs(t, storage.DeleteUser(userID, retention), errs.ErrUserCredentials)
+
+		orders, err := storage.SearchOrders(model.AdminOrderFilter{Number: order})
+		require.NoError(t, err)
+		if retention == "anonymize" {
+			require.Len(t, orders, 1, "orders are kept")
+			require.Len(t, anonymized(userID), 1)
+		} else {
+			require.Empty(t, orders)
+			require.Empty(t, anonymized(userID))
+		}
+	}
+
+	// an admin's record stays, anonymized, for the audit and the adjustments made
+	for _, retention := range []string{"anonymize", "delete"} {
+		admin := newUser(t, storage)
+		customer := newUser(t, storage)
+		require.NoError(t, storage.AddAdminAudit(admin, "users.search", "", nil))
+		adjustment, err := storage.CreateAdjustment(model.BalanceAdjustment{
+			UserID: customer, Amount: 5, Reason: "goodwill", Status: model.AdjustmentApplied, CreatedBy: admin,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, storage.DeleteUser(admin, retention), retention)
+		_, _, err = storage.GetUserAccess(admin)
+		require.ErrorIs(t, err, errs.ErrUserNotFound)
+		users := anonymized(admin)
+		require.Len(t, users, 1)
+		require.NotNil(t, users[0].DeletedAt)
+
+		adjustments, err := storage.GetAdjustments(model.AdjustmentApplied)
+		require.NoError(t, err)
+		found := slices.IndexFunc(adjustments, func(a model.BalanceAdjustment) bool { return a.ID == adjustment.ID })
+		require.NotEqual(t, -1, found)
+		require.Equal(t, admin, adjustments[found].CreatedBy)
+	}
+}
+
+func testDuplicateOrders(t *testing.T, storage repository.Storage) {
+	owner := newUser(t, storage)
+	other := newUser(t, storage)
+	order := newOrder()
+
+	require.NoError(t, storage.AddOrder(owner, order, 0))
+	require.ErrorIs(t, storage.AddOrder(owner, order, 0), errs.ErrOrderUploadedByUser)
+	require.ErrorIs(t, storage.AddOrder(other, order, 0), errs.ErrOrderUploadedByOtherUser)
+
+	owned := newOrder()
+	require.NoError(t, storage.AddOrder(other, owned, 0))
+	added := newOrder()
+	results, err := storage.AddOrders(other, []string{added, order, owned, added})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.NoError(t, results[added])
+	require.ErrorIs(t, results[order], errs.ErrOrderUploadedByOtherUser)
+	require.ErrorIs(t, results[owned], errs.ErrOrderUploadedByUser)
+
+	require.ErrorIs(t, storage.AddOrder(owner, added, 0), errs.ErrOrderUploadedByOtherUser)
+}
+
+func testOrders(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+	other := newUser(t, storage)
+
+	orders, err := storage.GetOrders(userID)
+	require.NoError(t, err)
+	require.NotNil(t, orders)
+	require.Empty(t, orders)
+
+	processed := addOrder(t, storage, userID, "PROCESSED", 12.5)
+	time.Sleep(tick)
+	fresh := addOrder(t, storage, userID, "NEW", 0)
+	addOrder(t, storage, other, "NEW", 0)
+
+	orders, err = storage.GetOrders(userID)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	require.Equal(t, fresh, orders[0].Order)
+	require.Equal(t, "NEW", orders[0].Status)
+	require.Zero(t, orders[0].Accrual)
+	require.Equal(t, processed, orders[1].Order)
+	require.Equal(t, "PROCESSED", orders[1].Status)
+	require.Equal(t, 12.5, orders[1].Accrual)
+
+	require.ErrorIs(t, storage.CancelOrder(other, fresh), errs.ErrOrderNotFound)
+	require.ErrorIs(t, storage.CancelOrder(userID, processed), errs.ErrOrderNotCancellable)
+	require.ErrorIs(t, storage.CancelOrder(userID, newOrder()), errs.ErrOrderNotFound)
+	require.NoError(t, storage.CancelOrder(userID, fresh))
+
+	orders, err = storage.GetOrders(userID)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	// a cancelled order can be uploaded again
+	require.NoError(t, storage.AddOrder(other, fresh, 0))
+}
+
+func testProcessingOrders(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+	statuses := map[string]bool{"NEW": true, "PROCESSING": true, "INVALID": false, "PROCESSED": false}
+	orders := make(map[string]string)
+	for status := range statuses {
+		orders[status] = addOrder(t, storage, userID, status, 0)
+	}
+
+	queued, err := storage.GetProcessingOrders()
+	require.NoError(t, err)
+	for status, selected := range statuses {
+		require.Equal(t, selected, slices.Contains(queued, orders[status]), status)
+	}
+
+	event, err := storage.RequeueOrder(orders["INVALID"])
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	require.Equal(t, orders["INVALID"], event.Order)
+	require.Equal(t, "NEW", event.Status)
+	events, err := storage.GetOrderEvents(userID, event.ID-1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	queued, err = storage.GetProcessingOrders()
+	require.NoError(t, err)
+	require.Contains(t, queued, orders["INVALID"])
+
+	event, err = storage.RequeueOrder(orders["NEW"])
+	require.NoError(t, err)
+	require.Nil(t, event, "the order is already queued")
+
+	_, err = storage.RequeueOrder(orders["PROCESSED"])
+	require.ErrorIs(t, err, errs.ErrOrderNotRequeueable)
+	_, err = storage.RequeueOrder(newOrder())
+	require.ErrorIs(t, err, errs.ErrOrderNotFound)
+}
+
+func testOrderEvents(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+	order := addOrder(t, storage, userID, "NEW", 0)
+
+	event, err := storage.UpdateAccrual(model.AccrualResp{Order: order, Status: "PROCESSING"})
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	require.Equal(t, userID, event.UserID)
+	require.Equal(t, order, event.Order)
+	require.Equal(t, "PROCESSING", event.Status)
+
+	event, err = storage.UpdateAccrual(model.AccrualResp{Order: order, Status: "PROCESSING"})
+	require.NoError(t, err)
+	require.Nil(t, event, "unchanged order")
+
+	event, err = storage.UpdateAccrual(model.AccrualResp{Order: newOrder(), Status: "PROCESSED", Accrual: 1})
+	require.NoError(t, err)
+	require.Nil(t, event, "unknown order")
+
+	processed, err := storage.UpdateAccrual(model.AccrualResp{Order: order, Status: "PROCESSED", Accrual: 7.25})
+	require.NoError(t, err)
+	require.NotNil(t, processed)
+	require.Equal(t, 7.25, processed.Accrual)
+
+	events, err := storage.GetOrderEvents(userID, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "PROCESSING", events[0].Status)
+	require.Equal(t, *processed, withoutTime(events[1], processed.ChangedAt))
+
+	events, err = storage.GetOrderEvents(userID, events[0].ID)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, processed.ID, events[0].ID)
+}
+
+// withoutTime replaces the event time, which may lose precision in the database.
+func withoutTime(event model.OrderEvent, changedAt time.Time) model.OrderEvent {
+	event.ChangedAt = changedAt
+	return event
+}
+
+func testBalance(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+
+	balance, err := storage.GetBalance(userID)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{}, balance)
+
+	addOrder(t, storage, userID, "PROCESSED", 0.1)
+	addOrder(t, storage, userID, "PROCESSED", 0.2)
+	addOrder(t, storage, userID, "PROCESSING", 0)
+	addOrder(t, storage, newUser(t, storage), "PROCESSED", 100)
+
+	balance, err = storage.GetBalance(userID)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0.3}, balance)
+
+	require.NoError(t, storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 0.25}, nil, nil))
+	balance, err = storage.GetBalance(userID)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0.05, Withdrawn: 0.25}, balance)
+
+	err = storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 0.06}, nil, nil)
+	require.ErrorIs(t, err, errs.ErrBalanceNotEnoughPoints)
+	require.NoError(t, storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 0.05}, nil, nil))
+
+	balance, err = storage.GetBalance(userID)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 0, Withdrawn: 0.3}, balance)
+}
+
+func testWithdrawals(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+	other := newUser(t, storage)
+
+	withdrawals, err := storage.GetWithdrawals(userID)
+	require.NoError(t, err)
+	require.NotNil(t, withdrawals)
+	require.Empty(t, withdrawals)
+
+	addOrder(t, storage, userID, "PROCESSED", 100)
+	addOrder(t, storage, other, "PROCESSED", 100)
+	var orders []string
+	for _, sum := range []float64{10, 20, 30} {
+		order := newOrder()
+		require.NoError(t, storage.NewWithdrawal(userID, model.Withdraw{Order: order, Sum: sum}, nil, nil))
+		orders = append(orders, order)
+		time.Sleep(tick)
+	}
+	require.NoError(t, storage.NewWithdrawal(other, model.Withdraw{Order: newOrder(), Sum: 40}, nil, nil))
+
+	withdrawals, err = storage.GetWithdrawals(userID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 3)
+	for i, withdrawal := range withdrawals {
+		require.Equal(t, orders[2-i], withdrawal.Order, "newest first")
+		require.Equal(t, float64(30-10*i), withdrawal.Sum)
+	}
+	require.Error(t, storage.NewWithdrawal(userID, model.Withdraw{Order: orders[0], Sum: 1}, nil, nil), "duplicate order")
+
+	t.Run("two-factor code", func(t *testing.T) {
+		userID := newUser(t, storage)
+		addOrder(t, storage, userID, "PROCESSED", 100)
+		counter := func(c int64) *int64 { return &c }
+
+		err := storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 1000}, counter(5), nil)
+		require.ErrorIs(t, err, errs.ErrBalanceNotEnoughPoints)
+		require.NoError(t, storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 10}, counter(5), nil),
+			"a failed withdrawal doesn't use up the code")
+		err = storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 10}, counter(5), nil)
+		require.ErrorIs(t, err, errs.ErrInvalidTOTPCode, "replayed code")
+
+		withdrawals, err := storage.GetWithdrawals(userID)
+		require.NoError(t, err)
+		require.Error(t, storage.NewWithdrawal(userID, model.Withdraw{Order: withdrawals[0].Order, Sum: 10}, counter(6), nil), "duplicate order")
+		require.NoError(t, storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 10}, counter(6), nil))
+		fresh, err := storage.UseTOTPCounter(userID, 6)
+		require.NoError(t, err)
+		require.False(t, fresh)
+
+		balance, err := storage.GetBalance(userID)
+		require.NoError(t, err)
+		require.Equal(t, 80.0, balance.Current)
+	})
+
+	t.Run("webhook event", func(t *testing.T) {
+		userID := newUser(t, storage)
+		addOrder(t, storage, userID, "PROCESSED", 100)
+		webhook, err := storage.CreateWebhook(userID, "https://example.com/hook", "secret", []string{"balance.withdrawn"})
+		require.NoError(t, err)
+		event := &model.WebhookEvent{Event: "balance.withdrawn", Payload: []byte(`{"sum":10}`)}
+
+		err = storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 1000}, nil, event)
+		require.ErrorIs(t, err, errs.ErrBalanceNotEnoughPoints)
+		require.NoError(t, storage.NewWithdrawal(userID, model.Withdraw{Order: newOrder(), Sum: 10}, nil, event))
+
+		deliveries, err := storage.GetWebhookDeliveries(userID, webhook.ID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1, "only the committed withdrawal is reported")
+		require.Equal(t, "balance.withdrawn", deliveries[0].Event)
+		require.JSONEq(t, `{"sum":10}`, string(deliveries[0].Payload))
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		userID := newUser(t, storage)
+		addOrder(t, storage, userID, "PROCESSED", 100)
+
+		const attempts = 10
+		results := make(chan error, attempts)
+		var wg sync.WaitGroup
+		for i := 0; i < attempts; i++ {
+			order := newOrder()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- storage.NewWithdrawal(userID, model.Withdraw{Order: order, Sum: 30}, nil, nil)
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for err := range results {
+			if err == nil {
+				succeeded++
+			} else {
+				require.ErrorIs(t, err, errs.ErrBalanceNotEnoughPoints)
+			}
+		}
+		require.Equal(t, 3, succeeded)
+		balance, err := storage.GetBalance(userID)
+		require.NoError(t, err)
+		require.Equal(t, model.Balance{Current: 10, Withdrawn: 90}, balance, "never overdrawn")
+	})
+}
+
+func testAdjustments(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+	admin := newUser(t, storage)
+	reviewer := newUser(t, storage)
+
+	version, err := storage.GetDataVersion(userID)
+	require.NoError(t, err)
+
+	applied, err := storage.CreateAdjustment(model.BalanceAdjustment{
+		UserID: userID, Amount: 5, Reason: "goodwill", Status: model.AdjustmentApplied, CreatedBy: admin,
+	})
+	require.NoError(t, err)
+	require.Equal(t, model.AdjustmentApplied, applied.Status)
+	pending, err := storage.CreateAdjustment(model.BalanceAdjustment{
+		UserID: userID, Amount: -2, Reason: "correction", Comment: "double accrual", Status: model.AdjustmentPending, CreatedBy: admin,
+	})
+	require.NoError(t, err)
+
+	_, err = storage.CreateAdjustment(model.BalanceAdjustment{
+		UserID: -1, Amount: 1, Reason: "goodwill", Status: model.AdjustmentApplied, CreatedBy: admin,
+	})
+	require.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	newVersion, err := storage.GetDataVersion(userID)
+	require.NoError(t, err)
+	require.Greater(t, newVersion, version)
+
+	balance, err := storage.GetBalance(userID)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 5}, balance, "pending adjustments don't count")
+
+	_, err = storage.ReviewAdjustment(pending.ID, admin, true)
+	require.ErrorIs(t, err, errs.ErrAdjustmentSelfApproval)
+	_, err = storage.ReviewAdjustment(pending.ID, userID, true)
+	require.ErrorIs(t, err, errs.ErrAdjustmentSelfApproval, "the adjusted user can't approve")
+	reviewed, err := storage.ReviewAdjustment(pending.ID, reviewer, true)
+	require.NoError(t, err)
+	require.Equal(t, model.AdjustmentApplied, reviewed.Status)
+	require.Equal(t, reviewer, reviewed.ReviewedBy)
+	require.NotNil(t, reviewed.ReviewedAt)
+	_, err = storage.ReviewAdjustment(pending.ID, reviewer, false)
+	require.ErrorIs(t, err, errs.ErrAdjustmentReviewed)
+	_, err = storage.ReviewAdjustment(-1, reviewer, false)
+	require.ErrorIs(t, err, errs.ErrAdjustmentNotFound)
+
+	balance, err = storage.GetBalance(userID)
+	require.NoError(t, err)
+	require.Equal(t, model.Balance{Current: 3}, balance)
+
+	adjustments, err := storage.GetUserAdjustments(userID)
+	require.NoError(t, err)
+	require.Len(t, adjustments, 2)
+	require.Equal(t, pending.ID, adjustments[0].ID)
+	require.Equal(t, -2.0, adjustments[0].Amount)
+	require.Empty(t, adjustments[0].Comment, "admin-only details are hidden")
+}
+
+func testSessions(t *testing.T, storage repository.Storage) {
+	userID := newUser(t, storage)
+	sessionID := newLogin()
+	client := model.ClientInfo{IP: "192.0.2.1", UserAgent: "storagetest"}
+	require.NoError(t, storage.CreateSession(userID, sessionID, sessionID+"-1", time.Hour, client))
+
+	active, err := storage.IsSessionActive(sessionID)
+	require.NoError(t, err)
+	require.True(t, active)
+
+	id, rotated, err := storage.RotateRefreshToken(sessionID+"-1", sessionID+"-2", time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, userID, id)
+	require.Equal(t, sessionID, rotated)
+
+	sessions, err := storage.GetSessions(userID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, client.UserAgent, sessions[0].UserAgent)
+	require.Equal(t, client.IP, sessions[0].IP)
+
+	_, _, err = storage.RotateRefreshToken(sessionID+"-1", sessionID+"-3", time.Hour)
+	require.ErrorIs(t, err, errs.ErrInvalidRefreshToken, "reused token")
+	active, err = storage.IsSessionActive(sessionID)
+	require.NoError(t, err)
+	require.False(t, active, "reuse revokes the session")
+	_, _, err = storage.RotateRefreshToken(sessionID+"-2", sessionID+"-3", time.Hour)
+	require.ErrorIs(t, err, errs.ErrInvalidRefreshToken)
+
+	require.ErrorIs(t, storage.RevokeSession(userID, sessionID), errs.ErrSessionNotFound)
+	active, err = storage.IsSessionActive(newLogin())
+	require.NoError(t, err)
+	require.False(t, active, "unknown session")
+}
+
+func testChallenges(t *testing.T, storage repository.Storage) {
+	challengeID := newLogin()
+	fresh, err := storage.UseChallenge(challengeID, time.Hour)
+	require.NoError(t, err)
+	require.True(t, fresh)
+	fresh, err = storage.UseChallenge(challengeID, time.Hour)
+	require.NoError(t, err)
+	require.False(t, fresh, "used challenge")
+	fresh, err = storage.UseChallenge(newLogin(), time.Hour)
+	require.NoError(t, err)
+	require.True(t, fresh)
+}
+
+func testAdmins(t *testing.T, storage repository.Storage) {
+	role := func(userID int) string {
+		t.Helper()
+		role, _, err := storage.GetUserAccess(userID)
+		require.NoError(t, err)
+		return role
+	}
+
+	first, second := newLogin(), newLogin()
+	firstID, err := storage.Register(first, "hash")
+	require.NoError(t, err)
+	secondID, err := storage.Register(second, "hash")
+	require.NoError(t, err)
+	sessionID := newLogin()
+	require.NoError(t, storage.CreateSession(firstID, sessionID, sessionID, time.Hour, model.ClientInfo{}))
+
+	promoted, _, err := storage.SyncAdmins([]string{first, newLogin()})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), promoted, "unknown logins are skipped")
+	require.Equal(t, model.RoleAdmin, role(firstID))
+	require.Equal(t, model.RoleUser, role(secondID))
+
+	promoted, demoted, err := storage.SyncAdmins([]string{second})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), promoted)
+	require.GreaterOrEqual(t, demoted, int64(1))
+	require.Equal(t, model.RoleUser, role(firstID), "admins no longer listed are demoted")
+	require.Equal(t, model.RoleAdmin, role(secondID))
+	active, err := storage.IsSessionActive(sessionID)
+	require.NoError(t, err)
+	require.False(t, active, "demoted admins are logged out")
+
+	_, demoted, err = storage.SyncAdmins(nil)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, demoted, int64(1))
+	require.Equal(t, model.RoleUser, role(secondID))
+
+	users, err := storage.SearchUsers(strings.ToUpper(first))
+	require.NoError(t, err)
+	found := slices.IndexFunc(users, func(user model.AdminUser) bool { return user.ID == firstID })
+	require.NotEqual(t, -1, found, "search is case-insensitive")
+	require.Equal(t, first, users[found].Login)
+	require.Equal(t, model.RoleUser, users[found].Role)
+
+	sessionID = newLogin()
+	require.NoError(t, storage.CreateSession(firstID, sessionID, sessionID, time.Hour, model.ClientInfo{}))
+	require.NoError(t, storage.SetUserBlocked(firstID, true))
+	_, blocked, err := storage.GetUserAccess(firstID)
+	require.NoError(t, err)
+	require.True(t, blocked)
+	active, err = storage.IsSessionActive(sessionID)
+	require.NoError(t, err)
+	require.False(t, active, "blocking logs the user out")
+	require.NoError(t, storage.SetUserBlocked(firstID, false))
+	_, blocked, err = storage.GetUserAccess(firstID)
+	require.NoError(t, err)
+	require.False(t, blocked)
+}
+
+func testPartnerNonces(t *testing.T, storage repository.Storage) {
+	first, err := storage.CreatePartner(model.Partner{Name: "storagetest", KeyID: "pk_" + newLogin(), Secret: "secret"})
+	require.NoError(t, err)
+	second, err := storage.CreatePartner(model.Partner{Name: "storagetest", KeyID: "pk_" + newLogin(), Secret: "secret"})
+	require.NoError(t, err)
+	nonce := newLogin()
+
+	fresh, err := storage.UsePartnerNonce(first.ID, nonce, time.Hour)
+	require.NoError(t, err)
+	require.True(t, fresh)
+	fresh, err = storage.UsePartnerNonce(first.ID, nonce, time.Hour)
+	require.NoError(t, err)
+	require.False(t, fresh, "replayed nonce")
+	fresh, err = storage.UsePartnerNonce(second.ID, nonce, time.Hour)
+	require.NoError(t, err)
+	require.True(t, fresh, "nonces are per partner")
+
+	expiring := newLogin()
+	fresh, err = storage.UsePartnerNonce(first.ID, expiring, tick)
+	require.NoError(t, err)
+	require.True(t, fresh)
+	time.Sleep(2 * tick)
+	fresh, err = storage.UsePartnerNonce(first.ID, expiring, time.Hour)
+	require.NoError(t, err)
+	require.True(t, fresh, "expired nonces are forgotten")
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"time"
+)
+
+// GetLoginLock returns how long the most restrictive of the keys stays locked.
+func (r *Repo) GetLoginLock(keys []string) (time.Duration, error) {
+	query := `
+		SELECT coalesce(extract(epoch FROM max(locked_until) - now()), 0)
+		FROM login_throttle WHERE key = ANY ($1) AND locked_until > now()`
+	var seconds float64
+	if err := r.db.QueryRow(query, keys).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// RecordLoginFailure increments the failure counter of the key and returns it.
+// The counter starts over if the previous failure is older than window.
+func (r *Repo) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_throttle (key, failures, last_failure_at) VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttle.last_failure_at < now() - make_interval(secs => $2) THEN 1
+				ELSE login_throttle.failures + 1
+			END,
+			last_failure_at = now()
+		RETURNING failures`
+	var failures int
+	err := r.db.QueryRow(query, key, window.Seconds()).Scan(&failures)
+	return failures, err
+}
+
+func (r *Repo) LockLogin(key string, duration time.Duration) error {
+	query := `
+		UPDATE login_throttle SET locked_until = greatest(coalesce(locked_until, now()), now() + make_interval(secs => $2))
+		WHERE key = $1`
+	_, err := r.db.Exec(query, key, duration.Seconds())
+	return err
+}
+
+func (r *Repo) ResetLoginFailures(keys []string) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM login_throttle WHERE key = ANY ($1)", keys)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"time"
+)
+
+// GetTOTP returns the user's TOTP secret, empty if not enrolled, and whether 2FA is enabled.
+func (r *Repo) GetTOTP(userID int) (string, bool, error) {
+	var secret sql.NullString
+	var enabled bool
+	query := "SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 AND deleted_at IS NULL"
+	err := r.db.QueryRow(query, userID).Scan(&secret, &enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, errs.ErrUserNotFound
+	}
+	return secret.String, enabled, err
+}
+
+// SetTOTPSecret starts a new enrollment. It fails if 2FA is already enabled.
+func (r *Repo) SetTOTPSecret(userID int, secret string) error {
+	query := `
+		UPDATE users SET totp_secret = $2, totp_last_counter = NULL
+		WHERE id = $1 AND totp_enabled_at IS NULL`
+	res, err := r.db.Exec(query, userID, secret)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errs.ErrTOTPEnabled
+	}
+	return nil
+}
+
+// EnableTOTP completes the enrollment and replaces the recovery codes.
+func (r *Repo) EnableTOTP(userID int, counter int64, recoveryHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users SET totp_enabled_at = now(), totp_last_counter = $2
+		WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`
+	res, err := tx.Exec(query, userID, counter)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return errs.ErrTOTPEnabled
+	}
+
+	if err = replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	query := "INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])"
+	_, err := tx.Exec(query, userID, hashes)
+	return err
+}
+
+func (r *Repo) DisableTOTP(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_counter = NULL WHERE id = $1"
+	if _, err = tx.Exec(query, userID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPCounter marks the time step as used. It returns false if this or a later step
+// has already been used, i.e. the code is replayed.
+func (r *Repo) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	return useTOTPCounter(r.db, userID, counter)
+}
+
+func useTOTPCounter(db execer, userID int, counter int64) (bool, error) {
+	query := `
+		UPDATE users SET totp_last_counter = $2
+		WHERE id = $1 AND (totp_last_counter IS NULL OR totp_last_counter < $2)`
+	res, err := db.Exec(query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+	updated, err := res.RowsAffected()
+	return updated > 0, err
+}
+
+// UseRecoveryCode consumes the recovery code. It returns false if there is no such unused code.
+func (r *Repo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := "UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+	res, err := r.db.Exec(query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	updated, err := res.RowsAffected()
+	return updated > 0, err
+}
+
+// UseChallenge consumes the login challenge, remembering it for the ttl. It returns false if the
+// challenge has already been used. Expired challenges are forgotten along the way.
+func (r *Repo) UseChallenge(challengeID string, ttl time.Duration) (bool, error) {
+	if _, err := r.db.Exec("DELETE FROM used_challenges WHERE expires_at < now()"); err != nil {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO used_challenges (challenge_id, expires_at) VALUES ($1, now() + make_interval(secs => $2))
+		ON CONFLICT (challenge_id) DO NOTHING`
+	res, err := r.db.Exec(query, challengeID, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	return inserted > 0, err
+}
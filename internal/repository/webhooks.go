@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"database/sql"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"time"
+)
+
+const maxListedDeliveries = 100
+
+func (r *Repo) CreateWebhook(userID int, url string, secret string, events []string) (model.Webhook, error) {
+	webhook := model.Webhook{URL: url, Events: events, Secret: secret}
+	query := "INSERT INTO webhooks (user_id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING id, created_at"
+	err := r.db.QueryRow(query, userID, url, secret, events).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (r *Repo) GetWebhooks(userID int) ([]model.Webhook, error) {
+	query := "SELECT id, url, events, created_at FROM webhooks WHERE user_id = $1 AND deleted_at IS NULL ORDER BY id"
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]model.Webhook, 0)
+	for rows.Next() {
+		var webhook model.Webhook
+		err = rows.Scan(&webhook.ID, &webhook.URL, r.types.SQLScanner(&webhook.Events), &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deactivates the webhook and cancels its pending deliveries.
+// The delivery log is kept.
+func (r *Repo) DeleteWebhook(userID int, webhookID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE webhooks SET deleted_at = now() WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL"
+	res, err := tx.Exec(query, webhookID, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return errs.ErrWebhookNotFound
+	}
+
+	query = "UPDATE webhook_deliveries SET status = 'CANCELLED' WHERE webhook_id = $1 AND status = 'PENDING'"
+	if _, err = tx.Exec(query, webhookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Repo) GetWebhookDeliveries(userID int, webhookID int) ([]model.WebhookDelivery, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND user_id = $2)"
+	if err := r.db.QueryRow(query, webhookID, userID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errs.ErrWebhookNotFound
+	}
+
+	query = `
+		SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at,
+		       coalesce(response_code, 0), coalesce(last_error, ''), created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`
+	rows, err := r.db.Query(query, webhookID, maxListedDeliveries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]model.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		var payload []byte
+		var deliveredAt sql.NullTime
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status,
+			&delivery.Attempts, &delivery.NextAttemptAt, &delivery.ResponseCode, &delivery.LastError,
+			&delivery.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// EnqueueWebhookEvent schedules a delivery of the payload to every active webhook
+// of the user subscribed to the event.
+func (r *Repo) EnqueueWebhookEvent(userID int, event string, payload []byte) (int64, error) {
+	return enqueueWebhookEvent(r.db, userID, event, payload)
+}
+
+func enqueueWebhookEvent(db execer, userID int, event string, payload []byte) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3::jsonb FROM webhooks
+		WHERE user_id = $1 AND deleted_at IS NULL AND $2 = ANY (events)`
+	res, err := db.Exec(query, userID, event, string(payload))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries and postpones them by lease,
+// so that concurrent dispatchers don't pick the same deliveries.
+func (r *Repo) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'PENDING' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.attempts, d.created_at, w.url, w.secret`
+	rows, err := r.db.Query(query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		var payload []byte
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Attempts,
+			&delivery.CreatedAt, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt stores the outcome of a delivery attempt. A pending delivery
+// is retried after retryIn.
+func (r *Repo) RecordWebhookAttempt(delivery model.WebhookDelivery, retryIn time.Duration) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = now() + make_interval(secs => $4),
+		    response_code = nullif($5, 0), last_error = nullif($6, ''),
+		    delivered_at = CASE WHEN $2 = 'DELIVERED' THEN now() END
+		WHERE id = $1`
+	_, err := r.db.Exec(query, delivery.ID, delivery.Status, delivery.Attempts, retryIn.Seconds(),
+		delivery.ResponseCode, delivery.LastError)
+	return err
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/secret"
+	"go.uber.org/zap"
+)
+
+const resetTokenSize = 32
+
+// ChangePassword sets a new password and logs out all other sessions of the user.
+func (s *Service) ChangePassword(ctx context.Context, req model.PasswordChange, client model.ClientInfo) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := s.auth.GetSessionID(ctx)
+	if err != nil {
+		return err
+	}
+
+	login, err := s.verifyPassword(ctx, userID, req.CurrentPassword, client)
+	if err != nil {
+		return err
+	}
+
+	if err = s.validator.ValidatePassword("new_password", login, req.NewPassword); err != nil {
+		return err
+	}
+
+	passwordHash, err := s.passwords.Hash(ctx, req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if err = s.repo.SetPassword(userID, passwordHash, sessionID); err != nil {
+		return err
+	}
+
+	_, err = s.repo.ResetLoginFailures([]string{loginKey(login)})
+	return err
+}
+
+// RequestPasswordReset sends a reset token to the user. Unknown logins are silently
+// ignored so that the response doesn't reveal which accounts exist.
+func (s *Service) RequestPasswordReset(req model.PasswordResetRequest) error {
+	userID, _, err := s.repo.GetUserCredentials(req.Login)
+	if errors.Is(err, errs.ErrUserCredentials) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := secret.New(resetTokenSize)
+	if err != nil {
+		return err
+	}
+
+	if err = s.repo.CreatePasswordReset(userID, secret.Hash(token), s.cfg.PasswordResetTTL); err != nil {
+		return err
+	}
+
+	return s.resets.SendPasswordReset(req.Login, token)
+}
+
+// ResetPassword sets a new password by a reset token and logs out all sessions of the user.
+func (s *Service) ResetPassword(ctx context.Context, req model.PasswordReset) error {
+	tokenHash := secret.Hash(req.Token)
+	userID, err := s.repo.GetPasswordResetUser(tokenHash)
+	if err != nil {
+		return err
+	}
+
+	login, _, err := s.repo.GetUserCredentialsByID(userID)
+	if errors.Is(err, errs.ErrUserCredentials) {
+		return errs.ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.validator.ValidatePassword("new_password", login, req.NewPassword); err != nil {
+		return err
+	}
+
+	passwordHash, err := s.passwords.Hash(ctx, req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if err = s.repo.ResetPassword(tokenHash, passwordHash); err != nil {
+		return err
+	}
+
+	_, err = s.repo.ResetLoginFailures([]string{loginKey(login)})
+	return err
+}
+
+// DeleteAccount removes the user's account according to the configured retention policy.
+func (s *Service) DeleteAccount(ctx context.Context, req model.AccountDeletion, client model.ClientInfo) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	login, err := s.verifyPassword(ctx, userID, req.Password, client)
+	if err != nil {
+		return err
+	}
+
+	if err = s.repo.DeleteUser(userID, s.cfg.AccountRetention); err != nil {
+		return err
+	}
+	logger.Log.Info("account deleted", zap.Int("user", userID), zap.String("retention", s.cfg.AccountRetention))
+
+	_, err = s.repo.ResetLoginFailures([]string{loginKey(login)})
+	return err
+}
+
+// verifyPassword checks the password of an authenticated user and returns the user's login.
+// Wrong passwords count towards the same lockouts as failed logins, so that a stolen session
+// can't be used to guess the password.
+func (s *Service) verifyPassword(ctx context.Context, userID int, password string, client model.ClientInfo) (string, error) {
+	login, passwordHash, err := s.repo.GetUserCredentialsByID(userID)
+	if err != nil {
+		return "", err
+	}
+
+	throttleKeys := s.loginThrottleKeys(login, client.IP)
+	if err = s.checkLoginThrottle(throttleKeys); err != nil {
+		return "", err
+	}
+
+	ok := false
+	if !s.validator.PasswordTooLong(password) {
+		ok, _, err = s.passwords.Verify(ctx, password, passwordHash)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !ok {
+		if err = s.recordLoginFailure(throttleKeys); err != nil {
+			return "", err
+		}
+		return "", errs.ErrWrongPassword
+	}
+	return login, nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type resetRecorder struct {
+	tokens map[string]string
+}
+
+func (r *resetRecorder) SendPasswordReset(login string, token string) error {
+	r.tokens[login] = token
+	return nil
+}
+
+// registerUser registers the user and opens a session for it, returning the context of
+// requests made in that session.
+func registerUser(t *testing.T, svc *Service, repo *repository.MemRepo, creds model.UserCredentials) (int, context.Context) {
+	t.Helper()
+	_, err := svc.Register(context.Background(), creds, model.ClientInfo{})
+	require.NoError(t, err)
+	userID, _, err := repo.GetUserCredentials(creds.Login)
+	require.NoError(t, err)
+	return userID, openSession(t, repo, userID, "session"+strconv.Itoa(userID))
+}
+
+func openSession(t *testing.T, repo *repository.MemRepo, userID int, sessionID string) context.Context {
+	t.Helper()
+	require.NoError(t, repo.CreateSession(userID, sessionID, "refresh-"+sessionID, time.Hour, model.ClientInfo{}))
+	return context.WithValue(userContext(userID), middleware.SessionIDKey, sessionID)
+}
+
+func TestChangePassword(t *testing.T) {
+	svc, repo := newTestService(t)
+	creds := model.UserCredentials{Login: "user", Password: "secret"}
+	userID, ctx := registerUser(t, svc, repo, creds)
+	openSession(t, repo, userID, "other")
+
+	err := svc.ChangePassword(ctx, model.PasswordChange{CurrentPassword: "wrong", NewPassword: "changed"}, model.ClientInfo{})
+	require.ErrorIs(t, err, errs.ErrWrongPassword)
+	var verr *errs.ValidationError
+	err = svc.ChangePassword(ctx, model.PasswordChange{CurrentPassword: "secret", NewPassword: "user"}, model.ClientInfo{})
+	require.ErrorAs(t, err, &verr)
+	require.Contains(t, verr.Fields, "new_password")
+
+	require.NoError(t, svc.ChangePassword(ctx, model.PasswordChange{CurrentPassword: "secret", NewPassword: "changed"}, model.ClientInfo{}))
+	_, err = svc.Login(context.Background(), creds, model.ClientInfo{})
+	require.ErrorIs(t, err, errs.ErrUserCredentials)
+	_, err = svc.Login(context.Background(), model.UserCredentials{Login: "user", Password: "changed"}, model.ClientInfo{})
+	require.NoError(t, err)
+
+	active, err := repo.IsSessionActive("session" + strconv.Itoa(userID))
+	require.NoError(t, err)
+	require.True(t, active, "the current session is kept")
+	active, err = repo.IsSessionActive("other")
+	require.NoError(t, err)
+	require.False(t, active, "other sessions are logged out")
+}
+
+func TestPasswordCheckThrottle(t *testing.T) {
+	svc, repo := newTestService(t, func(cfg *config.Config) {
+		cfg.LoginMaxFailures = freeLoginFailures + 1
+		cfg.LoginLockoutDuration = time.Hour
+	})
+	creds := model.UserCredentials{Login: "user", Password: "secret"}
+	_, ctx := registerUser(t, svc, repo, creds)
+	client := model.ClientInfo{IP: "192.0.2.1"}
+
+	for i := 0; i < freeLoginFailures; i++ {
+		err := svc.ChangePassword(ctx, model.PasswordChange{CurrentPassword: "wrong", NewPassword: "changed"}, client)
+		require.ErrorIs(t, err, errs.ErrWrongPassword)
+	}
+	require.ErrorIs(t, svc.DeleteAccount(ctx, model.AccountDeletion{Password: "wrong"}, client), errs.ErrWrongPassword)
+
+	var retryErr *errs.RetryAfterError
+	err := svc.DeleteAccount(ctx, model.AccountDeletion{Password: "secret"}, client)
+	require.ErrorAs(t, err, &retryErr, "even the right password is refused")
+	require.ErrorIs(t, retryErr, errs.ErrTooManyLoginAttempts)
+	_, err = svc.Login(context.Background(), creds, client)
+	require.ErrorIs(t, err, errs.ErrTooManyLoginAttempts, "the login is locked out as well")
+
+	_, err = repo.ResetLoginFailures([]string{loginKey(creds.Login)})
+	require.NoError(t, err)
+	require.NoError(t, svc.ChangePassword(ctx, model.PasswordChange{CurrentPassword: "secret", NewPassword: "changed"}, client))
+}
+
+func TestResetPassword(t *testing.T) {
+	svc, repo := newTestService(t, func(cfg *config.Config) {
+		cfg.PasswordResetTTL = time.Minute
+	})
+	resets := &resetRecorder{tokens: make(map[string]string)}
+	svc.resets = resets
+	userID, _ := registerUser(t, svc, repo, model.UserCredentials{Login: "user", Password: "secret"})
+
+	require.NoError(t, svc.RequestPasswordReset(model.PasswordResetRequest{Login: "nobody"}))
+	require.Empty(t, resets.tokens, "unknown logins are ignored")
+	require.NoError(t, svc.RequestPasswordReset(model.PasswordResetRequest{Login: "user"}))
+	token := resets.tokens["user"]
+	require.NotEmpty(t, token)
+
+	err := svc.ResetPassword(context.Background(), model.PasswordReset{Token: "wrong", NewPassword: "changed"})
+	require.ErrorIs(t, err, errs.ErrInvalidResetToken)
+	require.NoError(t, svc.ResetPassword(context.Background(), model.PasswordReset{Token: token, NewPassword: "changed"}))
+	err = svc.ResetPassword(context.Background(), model.PasswordReset{Token: token, NewPassword: "again"})
+	require.ErrorIs(t, err, errs.ErrInvalidResetToken, "tokens are single use")
+
+	_, err = svc.Login(context.Background(), model.UserCredentials{Login: "user", Password: "changed"}, model.ClientInfo{})
+	require.NoError(t, err)
+	active, err := repo.IsSessionActive("session" + strconv.Itoa(userID))
+	require.NoError(t, err)
+	require.False(t, active, "all sessions are logged out")
+}
+
+func TestDeleteAccount(t *testing.T) {
+	for _, retention := range []string{"anonymize", "delete"} {
+		t.Run(retention, func(t *testing.T) {
+			svc, repo := newTestService(t, func(cfg *config.Config) {
+				cfg.AccountRetention = retention
+			})
+			creds := model.UserCredentials{Login: "user", Password: "secret"}
+			userID, ctx := registerUser(t, svc, repo, creds)
+			require.NoError(t, repo.AddOrder(userID, "12345678903", 0))
+
+			require.ErrorIs(t, svc.DeleteAccount(ctx, model.AccountDeletion{Password: "wrong"}, model.ClientInfo{}), errs.ErrWrongPassword)
+			require.NoError(t, svc.DeleteAccount(ctx, model.AccountDeletion{Password: "secret"}, model.ClientInfo{}))
+
+			_, err := svc.Login(context.Background(), creds, model.ClientInfo{})
+			require.ErrorIs(t, err, errs.ErrUserCredentials)
+			active, err := repo.IsSessionActive("session" + strconv.Itoa(userID))
+			require.NoError(t, err)
+			require.False(t, active)
+
+			orders, err := repo.SearchOrders(model.AdminOrderFilter{UserID: userID})
+			require.NoError(t, err)
+			users, err := repo.SearchUsers(model.AnonymizedLoginPrefix)
+			require.NoError(t, err)
+			if retention == "anonymize" {
+				require.Len(t, orders, 1, "orders are kept for accounting")
+				require.Len(t, users, 1)
+				require.Equal(t, model.AnonymizedLoginPrefix+strconv.Itoa(userID), users[0].Login)
+				require.NotNil(t, users[0].DeletedAt)
+			} else {
+				require.Empty(t, orders)
+				require.Empty(t, users)
+			}
+
+			var verr *errs.ValidationError
+			_, err = svc.Register(context.Background(), model.UserCredentials{Login: model.AnonymizedLoginPrefix + strconv.Itoa(userID), Password: "secret"}, model.ClientInfo{})
+			require.ErrorAs(t, err, &verr, "anonymized logins can't be registered")
+			_, err = svc.Register(context.Background(), creds, model.ClientInfo{})
+			require.NoError(t, err, "the login is free again")
+		})
+	}
+}
+
+func TestAnonymizedLoginRejected(t *testing.T) {
+	// even a pattern accepting anything doesn't let anonymized logins through
+	svc, _ := newTestService(t, func(cfg *config.Config) {
+		cfg.LoginPattern = `^.+$`
+	})
+	_, err := svc.Register(context.Background(), model.UserCredentials{Login: model.AnonymizedLoginPrefix + "1", Password: "secret"}, model.ClientInfo{})
+	var verr *errs.ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Contains(t, verr.Fields, "login")
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"github.com/kuznet1/gophermart/internal/model"
+	"go.uber.org/zap"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// audit records an admin action. It is called before the action is performed,
+// so that an action can't happen without a record.
+func (s *Service) audit(ctx context.Context, action string, target string, details any) error {
+	adminID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if details != nil {
+		if data, err = json.Marshal(details); err != nil {
+			return err
+		}
+	}
+
+	if err = s.repo.AddAdminAudit(adminID, action, target, data); err != nil {
+		return err
+	}
+	logger.Log.Info("admin action", zap.Int("admin", adminID), zap.String("action", action),
+		zap.String("target", target), zap.ByteString("details", data))
+	return nil
+}
+
+// SyncAdmins makes the logins listed in the config, and only them, admins. Without any
+// logins listed the roles are left as they are rather than demoting every admin.
+func (s *Service) SyncAdmins() error {
+	var logins []string
+	for _, login := range strings.Split(s.cfg.AdminLogins, ",") {
+		if login = strings.TrimSpace(login); login != "" {
+			logins = append(logins, login)
+		}
+	}
+	if len(logins) == 0 {
+		logger.Log.Info("no admin logins are configured, admin roles are left as they are")
+		return nil
+	}
+
+	promoted, demoted, err := s.repo.SyncAdmins(logins)
+	if err != nil {
+		return err
+	}
+	if promoted < int64(len(logins)) {
+		logger.Log.Warn("some admin logins are not registered", zap.Strings("logins", logins))
+	}
+	if demoted > 0 {
+		logger.Log.Warn("admins missing from the admin logins are demoted", zap.Int64("demoted", demoted))
+	}
+	return nil
+}
+
+func (s *Service) SearchUsers(ctx context.Context, login string) ([]model.AdminUser, error) {
+	if err := s.audit(ctx, "users.search", "", map[string]string{"login": login}); err != nil {
+		return nil, err
+	}
+	return s.repo.SearchUsers(login)
+}
+
+func (s *Service) SearchOrders(ctx context.Context, filter model.AdminOrderFilter) ([]model.AdminOrder, error) {
+	if err := s.audit(ctx, "orders.search", "", filter); err != nil {
+		return nil, err
+	}
+	return s.repo.SearchOrders(filter)
+}
+
+func (s *Service) GetUserBalance(ctx context.Context, userID int) (model.Balance, error) {
+	if err := s.audit(ctx, "balance.view", userTarget(userID), nil); err != nil {
+		return model.Balance{}, err
+	}
+	if _, _, err := s.repo.GetUserAccess(userID); err != nil {
+		return model.Balance{}, err
+	}
+	return s.repo.GetBalance(userID)
+}
+
+// RequeueOrder makes the order be sent to the accrual system again. Orders whose accrual
+// is already credited can't be requeued.
+func (s *Service) RequeueOrder(ctx context.Context, order string) error {
+	if err := s.audit(ctx, "order.requeue", order, nil); err != nil {
+		return err
+	}
+	event, err := s.repo.RequeueOrder(order)
+	if err != nil {
+		return err
+	}
+	if event != nil {
+		s.events.Publish(*event)
+	}
+	s.accrual.Signal()
+	return nil
+}
+
+// SetUserBlocked blocks or unblocks the account. A blocked user is logged out and can't log in.
+func (s *Service) SetUserBlocked(ctx context.Context, userID int, blocked bool) error {
+	action := "user.unblock"
+	if blocked {
+		action = "user.block"
+	}
+	if err := s.audit(ctx, action, userTarget(userID), nil); err != nil {
+		return err
+	}
+	return s.repo.SetUserBlocked(userID, blocked)
+}
+
+func userTarget(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// maxAdjustmentAmount is the largest amount that fits the numeric(8, 2) column.
+const maxAdjustmentAmount = 999999.99
+
+// CreateAdjustment changes the user's balance. Adjustments above the configured threshold
+// stay pending until another admin approves them, and admins can't adjust their own balance.
+func (s *Service) CreateAdjustment(ctx context.Context, userID int, req model.AdjustmentRequest) (model.BalanceAdjustment, error) {
+	// validated as stored, rounded to cents
+	amount := math.Round(req.Amount*100) / 100
+	verr := errs.NewValidationError()
+	if amount == 0 || math.Abs(amount) > maxAdjustmentAmount {
+		verr.Add("amount", fmt.Sprintf("must be non-zero and at most %.2f by absolute value", maxAdjustmentAmount))
+	}
+	if !slices.Contains(model.AdjustmentReasons, req.Reason) {
+		verr.Add("reason", "must be one of "+strings.Join(model.AdjustmentReasons, ", "))
+	}
+	if strings.TrimSpace(req.Comment) == "" {
+		verr.Add("comment", "must not be empty")
+	}
+	if err := verr.Err(); err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+
+	adminID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	if adminID == userID {
+		return model.BalanceAdjustment{}, errs.ErrAdjustmentOwnBalance
+	}
+
+	status := model.AdjustmentApplied
+	if s.cfg.AdjustmentThreshold > 0 && math.Abs(amount) > s.cfg.AdjustmentThreshold {
+		status = model.AdjustmentPending
+	}
+
+	if err = s.audit(ctx, "adjustment.create", userTarget(userID), req); err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	return s.repo.CreateAdjustment(model.BalanceAdjustment{
+		UserID:    userID,
+		Amount:    amount,
+		Reason:    req.Reason,
+		Comment:   req.Comment,
+		Status:    status,
+		CreatedBy: adminID,
+	})
+}
+
+func (s *Service) SearchAdjustments(ctx context.Context, status string) ([]model.BalanceAdjustment, error) {
+	if err := s.audit(ctx, "adjustments.search", "", map[string]string{"status": status}); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAdjustments(status)
+}
+
+// ReviewAdjustment approves or rejects a pending adjustment created by another admin
+// for someone else's balance.
+func (s *Service) ReviewAdjustment(ctx context.Context, adjustmentID int, approve bool) (model.BalanceAdjustment, error) {
+	reviewerID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+
+	action := "adjustment.reject"
+	if approve {
+		action = "adjustment.approve"
+	}
+	if err = s.audit(ctx, action, "adjustment:"+strconv.Itoa(adjustmentID), nil); err != nil {
+		return model.BalanceAdjustment{}, err
+	}
+	return s.repo.ReviewAdjustment(adjustmentID, reviewerID, approve)
+}
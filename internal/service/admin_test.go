@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// auditLog records the admin actions on top of the in-memory storage.
+type auditLog struct {
+	*repository.MemRepo
+	actions []string
+}
+
+func (a *auditLog) AddAdminAudit(adminID int, action string, target string, details []byte) error {
+	a.actions = append(a.actions, strconv.Itoa(adminID)+" "+action+" "+target)
+	return a.MemRepo.AddAdminAudit(adminID, action, target, details)
+}
+
+func TestAdminActions(t *testing.T) {
+	svc, repo := newTestService(t)
+	audit := &auditLog{MemRepo: repo}
+	svc.repo = audit
+
+	adminID, err := repo.Register("admin", "hash")
+	require.NoError(t, err)
+	admin := userContext(adminID)
+	admins := strconv.Itoa(adminID)
+	creds := model.UserCredentials{Login: "customer", Password: "secret"}
+	userID, _ := registerUser(t, svc, repo, creds)
+	user := "user:" + strconv.Itoa(userID)
+
+	t.Run("search users", func(t *testing.T) {
+		users, err := svc.SearchUsers(admin, "CUSTOM")
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, userID, users[0].ID)
+		require.Equal(t, model.RoleUser, users[0].Role)
+		require.Contains(t, audit.actions, admins+" users.search ")
+	})
+
+	t.Run("block", func(t *testing.T) {
+		require.NoError(t, svc.SetUserBlocked(admin, userID, true))
+		active, err := repo.IsSessionActive("session" + strconv.Itoa(userID))
+		require.NoError(t, err)
+		require.False(t, active, "sessions are revoked")
+		_, err = svc.Login(context.Background(), creds, model.ClientInfo{})
+		require.ErrorIs(t, err, errs.ErrAccountBlocked)
+
+		require.NoError(t, svc.SetUserBlocked(admin, userID, false))
+		_, err = svc.Login(context.Background(), creds, model.ClientInfo{})
+		require.NoError(t, err)
+		require.Contains(t, audit.actions, admins+" user.block "+user)
+		require.Contains(t, audit.actions, admins+" user.unblock "+user)
+	})
+
+	t.Run("requeue", func(t *testing.T) {
+		require.NoError(t, repo.AddOrder(userID, "12345678903", 0))
+		_, err := repo.UpdateAccrual(model.AccrualResp{Order: "12345678903", Status: "INVALID"})
+		require.NoError(t, err)
+		require.NoError(t, repo.AddOrder(userID, "2377225624", 0))
+		_, err = repo.UpdateAccrual(model.AccrualResp{Order: "2377225624", Status: "PROCESSED", Accrual: 10})
+		require.NoError(t, err)
+
+		events, unsubscribe := svc.events.Subscribe(userID)
+		defer unsubscribe()
+		require.NoError(t, svc.RequeueOrder(admin, "12345678903"))
+		select {
+		case event := <-events:
+			require.Equal(t, "12345678903", event.Order)
+			require.Equal(t, "NEW", event.Status)
+		case <-time.After(time.Second):
+			t.Fatal("requeue event is not published")
+		}
+		queued, err := repo.GetProcessingOrders()
+		require.NoError(t, err)
+		require.Contains(t, queued, "12345678903")
+		require.Contains(t, audit.actions, admins+" order.requeue 12345678903")
+
+		require.ErrorIs(t, svc.RequeueOrder(admin, "2377225624"), errs.ErrOrderNotRequeueable)
+		require.ErrorIs(t, svc.RequeueOrder(admin, "79927398713"), errs.ErrOrderNotFound)
+		balance, err := repo.GetBalance(userID)
+		require.NoError(t, err)
+		require.Equal(t, 10.0, balance.Current, "the credited accrual is kept")
+	})
+}
+
+func TestSyncAdmins(t *testing.T) {
+	svc, repo := newTestService(t)
+	role := func(userID int) string {
+		role, _, err := repo.GetUserAccess(userID)
+		require.NoError(t, err)
+		return role
+	}
+	firstID, err := repo.Register("first", "hash")
+	require.NoError(t, err)
+	secondID, err := repo.Register("second", "hash")
+	require.NoError(t, err)
+
+	svc.cfg.AdminLogins = " first, unknown ,"
+	require.NoError(t, svc.SyncAdmins())
+	require.Equal(t, model.RoleAdmin, role(firstID))
+	require.Equal(t, model.RoleUser, role(secondID))
+
+	svc.cfg.AdminLogins = "second"
+	require.NoError(t, svc.SyncAdmins())
+	require.Equal(t, model.RoleUser, role(firstID), "admins no longer listed are demoted")
+	require.Equal(t, model.RoleAdmin, role(secondID))
+
+	for _, logins := range []string{"", " , "} {
+		svc.cfg.AdminLogins = logins
+		require.NoError(t, svc.SyncAdmins())
+		require.Equal(t, model.RoleAdmin, role(secondID), "admins aren't demoted without any admin logins")
+	}
+}
+
+func TestCreateAdjustment(t *testing.T) {
+	svc, repo := newTestService(t, func(cfg *config.Config) {
+		cfg.AdjustmentThreshold = 100
+	})
+	firstID, err := repo.Register("first", "hash")
+	require.NoError(t, err)
+	secondID, err := repo.Register("second", "hash")
+	require.NoError(t, err)
+	userID, err := repo.Register("customer", "hash")
+	require.NoError(t, err)
+	first, second := userContext(firstID), userContext(secondID)
+	request := func(amount float64) model.AdjustmentRequest {
+		return model.AdjustmentRequest{Amount: amount, Reason: "goodwill", Comment: "delayed delivery"}
+	}
+	balance := func() float64 {
+		balance, err := repo.GetBalance(userID)
+		require.NoError(t, err)
+		return balance.Current
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		for _, amount := range []float64{0, 0.001, -0.004, 1000000, -999999.995} {
+			var verr *errs.ValidationError
+			_, err := svc.CreateAdjustment(first, userID, request(amount))
+			require.ErrorAs(t, err, &verr, "%v", amount)
+			require.Contains(t, verr.Fields, "amount")
+		}
+
+		_, err := svc.CreateAdjustment(first, firstID, request(10))
+		require.ErrorIs(t, err, errs.ErrAdjustmentOwnBalance)
+		require.Equal(t, 0.0, balance())
+	})
+
+	t.Run("applied", func(t *testing.T) {
+		adjustment, err := svc.CreateAdjustment(first, userID, request(10.006))
+		require.NoError(t, err)
+		require.Equal(t, model.AdjustmentApplied, adjustment.Status)
+		require.Equal(t, 10.01, adjustment.Amount, "rounded to cents")
+		require.Equal(t, firstID, adjustment.CreatedBy)
+		require.Equal(t, 10.01, balance())
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		adjustment, err := svc.CreateAdjustment(first, userID, request(-150))
+		require.NoError(t, err)
+		require.Equal(t, model.AdjustmentPending, adjustment.Status)
+		require.Equal(t, 10.01, balance(), "pending adjustments don't count")
+
+		_, err = svc.ReviewAdjustment(first, adjustment.ID, true)
+		require.ErrorIs(t, err, errs.ErrAdjustmentSelfApproval)
+		reviewed, err := svc.ReviewAdjustment(second, adjustment.ID, true)
+		require.NoError(t, err)
+		require.Equal(t, model.AdjustmentApplied, reviewed.Status)
+		require.Equal(t, secondID, reviewed.ReviewedBy)
+		require.InDelta(t, -139.99, balance(), 1e-9)
+
+		// an admin can't approve an adjustment of their own balance either
+		adjustment, err = svc.CreateAdjustment(first, secondID, request(500))
+		require.NoError(t, err)
+		_, err = svc.ReviewAdjustment(second, adjustment.ID, true)
+		require.ErrorIs(t, err, errs.ErrAdjustmentSelfApproval)
+	})
+
+	t.Run("user history", func(t *testing.T) {
+		pending, err := svc.CreateAdjustment(first, userID, request(200))
+		require.NoError(t, err)
+		_, err = svc.ReviewAdjustment(second, pending.ID, false)
+		require.NoError(t, err)
+
+		adjustments, err := svc.GetBalanceAdjustments(userContext(userID))
+		require.NoError(t, err)
+		require.Len(t, adjustments, 2, "rejected adjustments aren't shown")
+		require.Equal(t, -150.0, adjustments[0].Amount)
+		require.Equal(t, 10.01, adjustments[1].Amount)
+		for _, adjustment := range adjustments {
+			require.Empty(t, adjustment.Comment, "admin-only details are hidden")
+			require.Zero(t, adjustment.CreatedBy)
+		}
+	})
+}
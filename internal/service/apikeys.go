@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/secret"
+	"slices"
+	"strings"
+)
+
+const (
+	apiKeyPrefixSize = 4
+	apiKeySecretSize = 32
+	maxAPIKeyName    = 100
+)
+
+// CreateAPIKey issues a key with the requested scopes. The key is returned only once,
+// just its hash is stored.
+func (s *Service) CreateAPIKey(ctx context.Context, req model.APIKeyRequest) (model.APIKey, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return model.APIKey{}, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPIKeyName || len(req.Scopes) == 0 {
+		return model.APIKey{}, errs.ErrInvalidAPIKey
+	}
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(model.Scopes, scope) {
+			return model.APIKey{}, errs.ErrInvalidAPIKey
+		}
+	}
+
+	prefix := make([]byte, apiKeyPrefixSize)
+	if _, err = rand.Read(prefix); err != nil {
+		return model.APIKey{}, err
+	}
+	keySecret, err := secret.New(apiKeySecretSize)
+	if err != nil {
+		return model.APIKey{}, err
+	}
+
+	key := model.APIKey{Name: name, Prefix: middleware.APIKeyPrefix + hex.EncodeToString(prefix), Scopes: scopes}
+	key.Key = key.Prefix + "_" + keySecret
+	created, err := s.repo.CreateAPIKey(userID, key, secret.Hash(key.Key))
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	return created, nil
+}
+
+func (s *Service) GetAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetAPIKeys(userID)
+}
+
+func (s *Service) RevokeAPIKey(ctx context.Context, keyID int) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.RevokeAPIKey(userID, keyID)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/secret"
+	"strconv"
+	"strings"
+)
+
+const (
+	partnerKeyIDSize  = 8
+	partnerSecretSize = 32
+	maxPartnerName    = 100
+	maxExternalID     = 255
+)
+
+// CreatePartner registers a partner. The signing secret is returned only once.
+func (s *Service) CreatePartner(ctx context.Context, req model.PartnerRequest) (model.Partner, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxPartnerName {
+		return model.Partner{}, errs.ErrInvalidPartner
+	}
+
+	keyID := make([]byte, partnerKeyIDSize)
+	if _, err := rand.Read(keyID); err != nil {
+		return model.Partner{}, err
+	}
+	partnerSecret, err := secret.New(partnerSecretSize)
+	if err != nil {
+		return model.Partner{}, err
+	}
+
+	partner := model.Partner{Name: name, KeyID: "pk_" + hex.EncodeToString(keyID), Secret: partnerSecret}
+	if err = s.audit(ctx, "partner.create", partner.KeyID, req); err != nil {
+		return model.Partner{}, err
+	}
+	return s.repo.CreatePartner(partner)
+}
+
+func (s *Service) GetPartners(ctx context.Context) ([]model.Partner, error) {
+	if err := s.audit(ctx, "partners.list", "", nil); err != nil {
+		return nil, err
+	}
+	return s.repo.GetPartners()
+}
+
+func (s *Service) RevokePartner(ctx context.Context, partnerID int) error {
+	if err := s.audit(ctx, "partner.revoke", "partner:"+strconv.Itoa(partnerID), nil); err != nil {
+		return err
+	}
+	return s.repo.RevokePartner(partnerID)
+}
+
+// LinkPartnerCustomer lets the partner refer to the user by its own customer ID.
+func (s *Service) LinkPartnerCustomer(ctx context.Context, customer model.PartnerCustomer) error {
+	partnerID, err := middleware.GetPartnerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if customer.ExternalID == "" || len(customer.ExternalID) > maxExternalID || customer.Login == "" {
+		return errs.ErrInvalidCustomer
+	}
+	return s.repo.LinkPartnerCustomer(partnerID, customer.ExternalID, customer.Login)
+}
+
+// NewPartnerOrder stores an order submitted by the partner for its customer, with the same
+// checks as orders uploaded by users.
+func (s *Service) NewPartnerOrder(ctx context.Context, order model.PartnerOrder) error {
+	partnerID, err := middleware.GetPartnerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if (order.Login == "") == (order.ExternalID == "") {
+		return errs.ErrInvalidCustomer
+	}
+
+	userID, err := s.repo.GetPartnerCustomer(partnerID, order)
+	if err != nil {
+		return err
+	}
+
+	return s.addOrder(userID, order.Number, partnerID)
+}
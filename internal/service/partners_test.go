@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewPartnerOrder(t *testing.T) {
+	svc, repo := newTestService(t)
+	partner, err := repo.CreatePartner(model.Partner{Name: "shop", KeyID: "pk_test", Secret: "secret"})
+	require.NoError(t, err)
+	ctx := context.WithValue(context.Background(), middleware.PartnerIDKey, partner.ID)
+	userID, err := repo.Register("customer", "hash")
+	require.NoError(t, err)
+	otherID, err := repo.Register("other", "hash")
+	require.NoError(t, err)
+	require.NoError(t, repo.AddOrder(otherID, "2377225624", 0))
+
+	err = svc.NewPartnerOrder(ctx, model.PartnerOrder{Number: "12345678904", Login: "customer"})
+	require.ErrorIs(t, err, errs.ErrInvalidOrderNum)
+	err = svc.NewPartnerOrder(ctx, model.PartnerOrder{Number: "2377225624", Login: "customer"})
+	require.ErrorIs(t, err, errs.ErrOrderUploadedByOtherUser)
+	err = svc.NewPartnerOrder(ctx, model.PartnerOrder{Number: "12345678903", Login: "customer", ExternalID: "c1"})
+	require.ErrorIs(t, err, errs.ErrInvalidCustomer)
+
+	require.NoError(t, svc.LinkPartnerCustomer(ctx, model.PartnerCustomer{ExternalID: "c1", Login: "customer"}))
+	require.NoError(t, svc.NewPartnerOrder(ctx, model.PartnerOrder{Number: "12345678903", ExternalID: "c1"}))
+	err = svc.NewPartnerOrder(ctx, model.PartnerOrder{Number: "12345678903", Login: "customer"})
+	require.ErrorIs(t, err, errs.ErrOrderUploadedByUser)
+
+	orders, err := repo.SearchOrders(model.AdminOrderFilter{Number: "12345678903"})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, userID, orders[0].UserID)
+	require.Equal(t, partner.ID, orders[0].PartnerID)
+}
@@ -2,23 +2,60 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/kuznet1/gophermart/internal/accrual"
+	"github.com/kuznet1/gophermart/internal/config"
 	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/events"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"github.com/kuznet1/gophermart/internal/luhn"
 	"github.com/kuznet1/gophermart/internal/middleware"
 	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/notify"
+	"github.com/kuznet1/gophermart/internal/password"
 	"github.com/kuznet1/gophermart/internal/repository"
-	"github.com/theplant/luhn"
-	"strconv"
+	"github.com/kuznet1/gophermart/internal/secret"
+	"github.com/kuznet1/gophermart/internal/webhook"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	maxOrderBatchSize = 1000
+	sessionIDSize     = 16
+	refreshTokenSize  = 32
 )
 
 type Service struct {
-	repo    *repository.Repo
-	auth    *middleware.Auth
-	accrual accrual.Accrualer
+	cfg       config.Config
+	repo      repository.Storage
+	auth      *middleware.Auth
+	accrual   accrual.Accrualer
+	events    *events.Broker
+	webhooks  *webhook.Dispatcher
+	validator *CredentialsValidator
+	passwords *password.Manager
+	resets    notify.Sender
 }
 
-func NewService(repo *repository.Repo, auth *middleware.Auth, accrual accrual.Accrualer) *Service {
-	return &Service{repo, auth, accrual}
+func NewService(cfg config.Config, repo repository.Storage, auth *middleware.Auth, accrual accrual.Accrualer, events *events.Broker, webhooks *webhook.Dispatcher, validator *CredentialsValidator, passwords *password.Manager, resets notify.Sender) *Service {
+	return &Service{
+		cfg:       cfg,
+		repo:      repo,
+		auth:      auth,
+		accrual:   accrual,
+		events:    events,
+		webhooks:  webhooks,
+		validator: validator,
+		passwords: passwords,
+		resets:    resets,
+	}
 }
 
 func (s *Service) NewOrder(ctx context.Context, order string) error {
@@ -27,20 +64,97 @@ func (s *Service) NewOrder(ctx context.Context, order string) error {
 		return err
 	}
 
-	orderID, err := strconv.Atoi(order)
-	if err != nil {
-		return errs.ErrInvalidOrderNum
-	}
+	return s.addOrder(userID, order, 0)
+}
 
-	if !luhn.Valid(orderID) {
+// addOrder stores the order of the user, submitted by the partner or by the user if partnerID is 0.
+func (s *Service) addOrder(userID int, order string, partnerID int) error {
+	if !luhn.Valid(order) {
 		return errs.ErrInvalidOrderNum
 	}
 
-	err = s.repo.AddOrder(userID, orderID)
+	err := s.repo.AddOrder(userID, order, partnerID)
 	s.accrual.Signal()
 	return err
 }
 
+func (s *Service) NewOrders(ctx context.Context, orders []string) ([]model.OrderBatchResult, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, errs.ErrEmptyBatch
+	}
+	if len(orders) > maxOrderBatchSize {
+		return nil, errs.ErrBatchTooLarge
+	}
+
+	validOrders := make([]string, 0, len(orders))
+	for _, order := range orders {
+		if luhn.Valid(order) {
+			validOrders = append(validOrders, order)
+		}
+	}
+
+	added := map[string]error{}
+	if len(validOrders) > 0 {
+		added, err = s.repo.AddOrders(userID, validOrders)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]model.OrderBatchResult, len(orders))
+	seen := make(map[string]bool, len(validOrders))
+	accepted := false
+	for i, order := range orders {
+		err = errs.ErrInvalidOrderNum
+		if luhn.Valid(order) {
+			err = added[order]
+			if seen[order] && err == nil {
+				// repeated within the batch: the first occurrence has been accepted
+				err = errs.ErrOrderUploadedByUser
+			}
+			seen[order] = true
+		}
+		accepted = accepted || err == nil
+		results[i] = newBatchResult(order, err)
+	}
+
+	if accepted {
+		s.accrual.Signal()
+	}
+	return results, nil
+}
+
+func newBatchResult(order string, err error) model.OrderBatchResult {
+	switch err {
+	case nil:
+		return model.OrderBatchResult{Order: order, Result: "accepted", Code: http.StatusAccepted}
+	case errs.ErrOrderUploadedByUser:
+		return model.OrderBatchResult{Order: order, Result: "uploaded", Code: errs.ErrOrderUploadedByUser.Code()}
+	case errs.ErrOrderUploadedByOtherUser:
+		return model.OrderBatchResult{Order: order, Result: "conflict", Code: errs.ErrOrderUploadedByOtherUser.Code()}
+	default:
+		return model.OrderBatchResult{Order: order, Result: "invalid", Code: errs.ErrInvalidOrderNum.Code()}
+	}
+}
+
+func (s *Service) CancelOrder(ctx context.Context, order string) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !luhn.Valid(order) {
+		return errs.ErrInvalidOrderNum
+	}
+
+	return s.repo.CancelOrder(userID, order)
+}
+
 func (s *Service) GetOrders(ctx context.Context) ([]model.Order, error) {
 	userID, err := s.auth.GetUserID(ctx)
 	if err != nil {
@@ -50,6 +164,86 @@ func (s *Service) GetOrders(ctx context.Context) ([]model.Order, error) {
 	return s.repo.GetOrders(userID)
 }
 
+// OrderEvents streams status and accrual changes of the user's orders until ctx is done.
+// If lastEventID is set, all the events stored after it are replayed before the live ones.
+func (s *Service) OrderEvents(ctx context.Context, lastEventID int64) (<-chan model.OrderEvent, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// subscribe before reading the history so that nothing falls in between
+	live, unsubscribe := s.events.Subscribe(userID)
+	var missed []model.OrderEvent
+	if lastEventID > 0 {
+		missed, err = s.repo.GetOrderEvents(userID, lastEventID)
+		if err != nil {
+			unsubscribe()
+			return nil, err
+		}
+	}
+
+	out := make(chan model.OrderEvent)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		lastSent := lastEventID
+		send := func(event model.OrderEvent) bool {
+			if event.ID <= lastSent {
+				return true
+			}
+			select {
+			case out <- event:
+				lastSent = event.ID
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// the history is read in pages, keep reading until caught up
+		for page := missed; len(page) > 0; {
+			for _, event := range page {
+				if !send(event) {
+					return
+				}
+			}
+			var err error
+			if page, err = s.repo.GetOrderEvents(userID, lastSent); err != nil {
+				logger.Log.Error("failed to replay order events", zap.Error(err))
+				return
+			}
+		}
+		for {
+			select {
+			case event, ok := <-live:
+				if !ok || !send(event) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// ETag returns a strong entity tag of the user's resource. It only depends on the
+// user's data version, so it can be checked without loading the resource itself.
+func (s *Service) ETag(ctx context.Context, resource string) (string, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := s.repo.GetDataVersion(userID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%s-%d-%d"`, resource, userID, version), nil
+}
+
 func (s *Service) GetBalance(ctx context.Context) (model.Balance, error) {
 	userID, err := s.auth.GetUserID(ctx)
 	if err != nil {
@@ -69,7 +263,50 @@ func (s *Service) Withdraw(ctx context.Context, withdraw model.Withdraw) error {
 		return errs.ErrInvalidOrderNum
 	}
 
-	return s.repo.NewWithdrawal(userID, withdraw)
+	// the code is used up by the withdrawal itself, once the balance is known to cover it
+	var totpCounter *int64
+	if withdraw.Sum > s.cfg.TOTPWithdrawThreshold {
+		twoFactor, err := s.twoFactorEnabled(userID)
+		if err != nil {
+			return err
+		}
+		if twoFactor && withdraw.TOTPCode == "" {
+			return errs.ErrTOTPRequired
+		}
+		if twoFactor {
+			counter, err := s.validateTOTP(userID, withdraw.TOTPCode)
+			if err != nil {
+				return err
+			}
+			totpCounter = &counter
+		}
+	}
+
+	event, err := webhook.NewEvent(webhook.EventBalanceWithdrawn, model.Withdrawal{
+		Order:       withdraw.Order,
+		Sum:         withdraw.Sum,
+		ProcessedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	err = s.repo.NewWithdrawal(userID, withdraw, totpCounter, &event)
+	if err != nil {
+		return err
+	}
+
+	s.webhooks.Signal()
+	return nil
+}
+
+// GetBalanceAdjustments returns the manual adjustments applied to the user's balance.
+func (s *Service) GetBalanceAdjustments(ctx context.Context) ([]model.BalanceAdjustment, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetUserAdjustments(userID)
 }
 
 func (s *Service) GetWithdrawals(ctx context.Context) ([]model.Withdrawal, error) {
@@ -81,20 +318,272 @@ func (s *Service) GetWithdrawals(ctx context.Context) ([]model.Withdrawal, error
 	return s.repo.GetWithdrawals(userID)
 }
 
-func (s *Service) Login(creds model.UserCredentials) (string, error) {
-	userID, err := s.repo.Login(creds)
+// Login checks the credentials and issues tokens, or a challenge if the user has
+// two-factor authentication enabled.
+func (s *Service) Login(ctx context.Context, creds model.UserCredentials, client model.ClientInfo) (model.LoginResult, error) {
+	throttleKeys := s.loginThrottleKeys(creds.Login, client.IP)
+	if err := s.checkLoginThrottle(throttleKeys); err != nil {
+		return model.LoginResult{}, err
+	}
+
+	userID, err := s.login(ctx, creds)
+	if errors.Is(err, errs.ErrUserCredentials) {
+		if throttleErr := s.recordLoginFailure(throttleKeys); throttleErr != nil {
+			return model.LoginResult{}, throttleErr
+		}
+	}
 	if err != nil {
-		return "", err
+		return model.LoginResult{}, err
 	}
 
-	return s.auth.CreateToken(userID)
+	twoFactor, err := s.twoFactorEnabled(userID)
+	if err != nil {
+		return model.LoginResult{}, err
+	}
+	if twoFactor {
+		challengeToken, expiresAt, err := s.auth.CreateChallengeToken(userID)
+		if err != nil {
+			return model.LoginResult{}, err
+		}
+		challenge := model.Challenge{ChallengeToken: challengeToken, Method: "totp", ExpiresAt: expiresAt}
+		return model.LoginResult{Challenge: &challenge}, nil
+	}
+
+	if _, err = s.repo.ResetLoginFailures([]string{loginKey(creds.Login)}); err != nil {
+		return model.LoginResult{}, err
+	}
+
+	tokens, err := s.issueTokens(userID, client)
+	if err != nil {
+		return model.LoginResult{}, err
+	}
+	return model.LoginResult{Tokens: &tokens}, nil
+}
+
+func (s *Service) login(ctx context.Context, creds model.UserCredentials) (int, error) {
+	if s.validator.PasswordTooLong(creds.Password) {
+		return 0, errs.ErrUserCredentials
+	}
+
+	userID, passwordHash, err := s.repo.GetUserCredentials(creds.Login)
+	if err != nil {
+		return 0, err
+	}
+
+	ok, needsRehash, err := s.passwords.Verify(ctx, creds.Password, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errs.ErrUserCredentials
+	}
+
+	if needsRehash {
+		s.rehashPassword(ctx, userID, creds.Password)
+	}
+	return userID, nil
 }
 
-func (s *Service) Register(creds model.UserCredentials) (string, error) {
-	userID, err := s.repo.Register(creds)
+// rehashPassword upgrades the stored hash to the current hashing parameters.
+// It is best-effort: the login succeeds even if the upgrade fails.
+func (s *Service) rehashPassword(ctx context.Context, userID int, password string) {
+	passwordHash, err := s.passwords.Hash(ctx, password)
+	if err == nil {
+		err = s.repo.UpdatePasswordHash(userID, passwordHash)
+	}
+	if err != nil {
+		logger.Log.Warn("failed to rehash password", zap.Int("user", userID), zap.Error(err))
+	}
+}
+
+func (s *Service) Register(ctx context.Context, creds model.UserCredentials, client model.ClientInfo) (model.Tokens, error) {
+	if err := s.validator.Validate(creds); err != nil {
+		return model.Tokens{}, err
+	}
+
+	passwordHash, err := s.passwords.Hash(ctx, creds.Password)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	userID, err := s.repo.Register(creds.Login, passwordHash)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return s.issueTokens(userID, client)
+}
+
+// Refresh rotates the refresh token and issues a new access token for the same session.
+func (s *Service) Refresh(refreshToken string) (model.Tokens, error) {
+	if refreshToken == "" {
+		return model.Tokens{}, errs.ErrInvalidRefreshToken
+	}
+
+	newRefreshToken, err := secret.New(refreshTokenSize)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	userID, sessionID, err := s.repo.RotateRefreshToken(secret.Hash(refreshToken), secret.Hash(newRefreshToken), s.cfg.RefreshTokenTTL)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	role, err := s.userRole(userID)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return s.newTokens(userID, sessionID, role, newRefreshToken)
+}
+
+func (s *Service) Logout(ctx context.Context) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := s.auth.GetSessionID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.RevokeSession(userID, sessionID)
+}
+
+// GetSessions returns the active sessions of the user, marking the one of the request.
+func (s *Service) GetSessions(ctx context.Context) ([]model.Session, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currentID, err := s.auth.GetSessionID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.repo.GetSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+func (s *Service) RevokeSession(ctx context.Context, sessionID string) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.RevokeSession(userID, sessionID)
+}
+
+func (s *Service) issueTokens(userID int, client model.ClientInfo) (model.Tokens, error) {
+	role, err := s.userRole(userID)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	sessionID, err := secret.New(sessionIDSize)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	refreshToken, err := secret.New(refreshTokenSize)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	if err = s.repo.CreateSession(userID, sessionID, secret.Hash(refreshToken), s.cfg.RefreshTokenTTL, client); err != nil {
+		return model.Tokens{}, err
+	}
+
+	return s.newTokens(userID, sessionID, role, refreshToken)
+}
+
+// userRole returns the role to put into the user's tokens. Blocked users get no tokens.
+func (s *Service) userRole(userID int) (string, error) {
+	role, blocked, err := s.repo.GetUserAccess(userID)
 	if err != nil {
 		return "", err
 	}
+	if blocked {
+		return "", errs.ErrAccountBlocked
+	}
+	return role, nil
+}
+
+func (s *Service) newTokens(userID int, sessionID string, role string, refreshToken string) (model.Tokens, error) {
+	accessToken, expiresAt, err := s.auth.CreateToken(userID, sessionID, role)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return model.Tokens{
+		TokenType:    "Bearer",
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+func (s *Service) CreateWebhook(ctx context.Context, req model.WebhookRequest) (model.Webhook, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || !s.webhooks.ValidURL(u) {
+		return model.Webhook{}, errs.ErrInvalidWebhook
+	}
+
+	events := req.Events
+	if len(events) == 0 {
+		events = webhook.Events
+	}
+	for _, event := range events {
+		if !slices.Contains(webhook.Events, event) {
+			return model.Webhook{}, errs.ErrInvalidWebhook
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return model.Webhook{}, err
+	}
+
+	return s.repo.CreateWebhook(userID, u.String(), "whsec_"+hex.EncodeToString(secret), events)
+}
+
+func (s *Service) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetWebhooks(userID)
+}
+
+func (s *Service) DeleteWebhook(ctx context.Context, webhookID int) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.DeleteWebhook(userID, webhookID)
+}
+
+func (s *Service) GetWebhookDeliveries(ctx context.Context, webhookID int) ([]model.WebhookDelivery, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	return s.auth.CreateToken(userID)
+	return s.repo.GetWebhookDeliveries(userID, webhookID)
 }
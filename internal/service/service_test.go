@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/events"
+	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/notify"
+	"github.com/kuznet1/gophermart/internal/password"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/kuznet1/gophermart/internal/webhook"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+type accrualStub struct{}
+
+func (accrualStub) Signal() {}
+
+func newTestService(t *testing.T, configure ...func(cfg *config.Config)) (*Service, *repository.MemRepo) {
+	t.Helper()
+	cfg := config.Config{
+		PasswordMinLength:     5,
+		PasswordHashAlgorithm: "bcrypt",
+		BcryptCost:            bcrypt.MinCost,
+		AccessTokenTTL:        time.Minute,
+		RefreshTokenTTL:       time.Hour,
+		AccountRetention:      "anonymize",
+		DevMode:               true,
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	repo := repository.NewMemRepo()
+	auth, err := middleware.NewAuth(cfg, repo)
+	require.NoError(t, err)
+	validator, err := NewCredentialsValidator(cfg)
+	require.NoError(t, err)
+	passwords, err := password.NewManager(cfg, password.NewPool(1, 8))
+	require.NoError(t, err)
+	svc := NewService(cfg, repo, auth, accrualStub{}, events.NewBroker(), webhook.NewDispatcher(cfg, repo), validator, passwords, notify.LogSender{})
+	return svc, repo
+}
+
+func userContext(userID int) context.Context {
+	return context.WithValue(context.Background(), middleware.UserIDKey, userID)
+}
+
+func TestOrderEventsReplay(t *testing.T) {
+	svc, repo := newTestService(t)
+	userID, err := repo.Register("user", "hash")
+	require.NoError(t, err)
+	require.NoError(t, repo.AddOrder(userID, "12345678903", 0))
+
+	// more events than fit into a page of the history
+	const total = 2500
+	statuses := []string{"PROCESSING", "NEW"}
+	for i := 0; i < total; i++ {
+		_, err = repo.UpdateAccrual(model.AccrualResp{Order: "12345678903", Status: statuses[i%2]})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(userContext(userID))
+	defer cancel()
+	stream, err := svc.OrderEvents(ctx, 1)
+	require.NoError(t, err)
+
+	for id := int64(2); id <= total; id++ {
+		select {
+		case event := <-stream:
+			require.Equal(t, id, event.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("event %d is not replayed", id)
+		}
+	}
+
+	svc.events.Publish(model.OrderEvent{ID: total + 1, UserID: userID, Status: "PROCESSED"})
+	select {
+	case event := <-stream:
+		require.Equal(t, int64(total+1), event.ID, "live events follow the replay")
+	case <-time.After(time.Second):
+		t.Fatal("live event is not delivered")
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	svc, repo := newTestService(t, func(cfg *config.Config) {
+		cfg.WebhookAllowedHosts = "hooks.internal"
+	})
+	userID, err := repo.Register("user", "hash")
+	require.NoError(t, err)
+	ctx := userContext(userID)
+
+	for _, rawURL := range []string{"ftp://example.com/hook", "http://localhost/hook", "http://127.0.0.1:8080/hook", "http://169.254.169.254/"} {
+		_, err = svc.CreateWebhook(ctx, model.WebhookRequest{URL: rawURL})
+		require.ErrorIs(t, err, errs.ErrInvalidWebhook, rawURL)
+	}
+
+	hook, err := svc.CreateWebhook(ctx, model.WebhookRequest{URL: "http://hooks.internal/gophermart"})
+	require.NoError(t, err)
+	require.Equal(t, webhook.Events, hook.Events)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	svc, _ := newTestService(t, func(cfg *config.Config) {
+		cfg.LoginThrottleDelay = time.Second
+		cfg.LoginLockoutDuration = 15 * time.Minute
+	})
+	tests := []struct {
+		failures    int
+		maxFailures int
+		delay       time.Duration
+	}{
+		{1, 10, 0},
+		{freeLoginFailures, 10, 0},
+		{freeLoginFailures + 1, 10, time.Second},
+		{freeLoginFailures + 2, 10, 2 * time.Second},
+		{freeLoginFailures + 4, 10, 8 * time.Second},
+		{10, 10, 15 * time.Minute},
+		{11, 10, 15 * time.Minute},
+		{freeLoginFailures + 11, 100, 15 * time.Minute},
+		{freeLoginFailures + 80, 100, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.delay, svc.loginDelay(tt.failures, tt.maxFailures), "%d of %d failures", tt.failures, tt.maxFailures)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	svc, repo := newTestService(t, func(cfg *config.Config) {
+		cfg.LoginMaxFailures = 5
+		cfg.LoginThrottleDelay = 50 * time.Millisecond
+		cfg.LoginLockoutDuration = time.Hour
+	})
+	client := model.ClientInfo{IP: "192.0.2.1"}
+	creds := model.UserCredentials{Login: "user", Password: "secret"}
+	wrong := model.UserCredentials{Login: "user", Password: "wrong"}
+	_, err := svc.Register(context.Background(), creds, client)
+	require.NoError(t, err)
+
+	login := func(creds model.UserCredentials) error {
+		_, err := svc.Login(context.Background(), creds, client)
+		return err
+	}
+
+	t.Run("backoff", func(t *testing.T) {
+		for i := 0; i < freeLoginFailures; i++ {
+			require.ErrorIs(t, login(wrong), errs.ErrUserCredentials)
+		}
+		require.NoError(t, login(creds), "failures below the limit are free")
+
+		for i := 0; i <= freeLoginFailures; i++ {
+			require.ErrorIs(t, login(wrong), errs.ErrUserCredentials)
+		}
+		var retryErr *errs.RetryAfterError
+		require.ErrorAs(t, login(creds), &retryErr, "even the right password is refused")
+		require.ErrorIs(t, retryErr, errs.ErrTooManyLoginAttempts)
+		require.Greater(t, retryErr.RetryAfter, time.Duration(0))
+		require.LessOrEqual(t, retryErr.RetryAfter, 50*time.Millisecond)
+	})
+
+	t.Run("lockout", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+		require.ErrorIs(t, login(wrong), errs.ErrUserCredentials)
+
+		var retryErr *errs.RetryAfterError
+		require.ErrorAs(t, login(creds), &retryErr)
+		require.Greater(t, retryErr.RetryAfter, 59*time.Minute)
+
+		other := model.UserCredentials{Login: "other", Password: "secret"}
+		_, err := svc.Register(context.Background(), other, client)
+		require.NoError(t, err)
+		require.NoError(t, login(other), "other logins aren't locked")
+	})
+
+	t.Run("admin unlock", func(t *testing.T) {
+		adminID, err := repo.Register("admin", "hash")
+		require.NoError(t, err)
+		ctx := userContext(adminID)
+
+		require.ErrorIs(t, svc.UnlockLogin(ctx, "", ""), errs.ErrLockoutNotFound)
+		require.ErrorIs(t, svc.UnlockLogin(ctx, "nobody", ""), errs.ErrLockoutNotFound)
+		require.NoError(t, svc.UnlockLogin(ctx, "user", ""))
+		require.NoError(t, login(creds))
+	})
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/middleware"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/secret"
+	"github.com/kuznet1/gophermart/internal/totp"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeSize gives the codes 80 bits of entropy, enough for an unsalted hash to be safe
+	// to store.
+	recoveryCodeSize = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnrollTOTP generates a new TOTP secret. Two-factor authentication is enabled only after
+// a code generated with it is confirmed.
+func (s *Service) EnrollTOTP(ctx context.Context) (model.TOTPEnrollment, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	login, _, err := s.repo.GetUserCredentialsByID(userID)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	totpSecret, err := totp.NewSecret()
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	if err = s.repo.SetTOTPSecret(userID, totpSecret); err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	return model.TOTPEnrollment{Secret: totpSecret, URI: totp.URI(s.cfg.TOTPIssuer, login, totpSecret)}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication and returns single-use recovery codes.
+func (s *Service) ConfirmTOTP(ctx context.Context, req model.TOTPCode) (model.RecoveryCodes, error) {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+
+	totpSecret, enabled, err := s.repo.GetTOTP(userID)
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	if enabled {
+		return model.RecoveryCodes{}, errs.ErrTOTPEnabled
+	}
+	if totpSecret == "" {
+		return model.RecoveryCodes{}, errs.ErrTOTPNotEnrolled
+	}
+
+	counter, ok := totp.Validate(totpSecret, req.Code, time.Now())
+	if !ok {
+		return model.RecoveryCodes{}, errs.ErrInvalidTOTPCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeSize)
+		if _, err = rand.Read(b); err != nil {
+			return model.RecoveryCodes{}, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		groups := make([]string, 0, len(code)/4)
+		for j := 0; j < len(code); j += 4 {
+			groups = append(groups, code[j:j+4])
+		}
+		codes[i] = strings.Join(groups, "-")
+		hashes[i] = secret.Hash(code)
+	}
+
+	if err = s.repo.EnableTOTP(userID, counter, hashes); err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	return model.RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableTOTP turns two-factor authentication off. It takes a TOTP or a recovery code.
+func (s *Service) DisableTOTP(ctx context.Context, req model.TOTPCode) error {
+	userID, err := s.auth.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = s.verifySecondFactor(userID, req.Code, true); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(userID)
+}
+
+// CompleteLogin exchanges a login challenge and a TOTP or recovery code for tokens.
+// Wrong codes count as failed logins. A challenge can be attempted only once, whether the code
+// is right or not, so every guess takes a password check.
+func (s *Service) CompleteLogin(req model.ChallengeResponse, client model.ClientInfo) (model.Tokens, error) {
+	userID, challengeID, err := s.auth.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		return model.Tokens{}, errs.ErrInvalidChallenge
+	}
+	fresh, err := s.repo.UseChallenge(challengeID, middleware.ChallengeTTL)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	if !fresh {
+		return model.Tokens{}, errs.ErrInvalidChallenge
+	}
+
+	login, _, err := s.repo.GetUserCredentialsByID(userID)
+	if errors.Is(err, errs.ErrUserCredentials) {
+		return model.Tokens{}, errs.ErrInvalidChallenge
+	}
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	throttleKeys := s.loginThrottleKeys(login, client.IP)
+	if err = s.checkLoginThrottle(throttleKeys); err != nil {
+		return model.Tokens{}, err
+	}
+
+	err = s.verifySecondFactor(userID, req.Code, true)
+	if errors.Is(err, errs.ErrInvalidTOTPCode) {
+		if throttleErr := s.recordLoginFailure(throttleKeys); throttleErr != nil {
+			return model.Tokens{}, throttleErr
+		}
+	}
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	if _, err = s.repo.ResetLoginFailures([]string{loginKey(login)}); err != nil {
+		return model.Tokens{}, err
+	}
+	return s.issueTokens(userID, client)
+}
+
+// twoFactorEnabled reports whether the user has confirmed TOTP enrollment.
+func (s *Service) twoFactorEnabled(userID int) (bool, error) {
+	_, enabled, err := s.repo.GetTOTP(userID)
+	return enabled, err
+}
+
+// validateTOTP checks a TOTP code without using it up and returns its time step counter,
+// for the caller to consume along with what the code authorizes.
+func (s *Service) validateTOTP(userID int, code string) (int64, error) {
+	totpSecret, enabled, err := s.repo.GetTOTP(userID)
+	if err != nil {
+		return 0, err
+	}
+	if !enabled {
+		return 0, errs.ErrTOTPNotEnrolled
+	}
+	counter, ok := totp.Validate(totpSecret, code, time.Now())
+	if !ok {
+		return 0, errs.ErrInvalidTOTPCode
+	}
+	return counter, nil
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code if allowed. Every code is
+// accepted only once.
+func (s *Service) verifySecondFactor(userID int, code string, allowRecovery bool) error {
+	counter, err := s.validateTOTP(userID, code)
+	if err == nil {
+		fresh, err := s.repo.UseTOTPCounter(userID, counter)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errs.ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	if !allowRecovery || !errors.Is(err, errs.ErrInvalidTOTPCode) {
+		return err
+	}
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	used, err := s.repo.UseRecoveryCode(userID, secret.Hash(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errs.ErrInvalidTOTPCode
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/totp"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enableTOTP enrolls the user in two-factor authentication with the code of the previous time
+// step, so that the codes of the current and the next steps are still unused.
+func enableTOTP(t *testing.T, svc *Service, ctx context.Context) (string, []string) {
+	t.Helper()
+	enrollment, err := svc.EnrollTOTP(ctx)
+	require.NoError(t, err)
+	code, err := totp.Code(enrollment.Secret, time.Now().Add(-totp.Period*time.Second))
+	require.NoError(t, err)
+	recovery, err := svc.ConfirmTOTP(ctx, model.TOTPCode{Code: code})
+	require.NoError(t, err)
+	return enrollment.Secret, recovery.Codes
+}
+
+func TestCompleteLogin(t *testing.T) {
+	svc, repo := newTestService(t)
+	creds := model.UserCredentials{Login: "user", Password: "secret"}
+	_, ctx := registerUser(t, svc, repo, creds)
+	_, codes := enableTOTP(t, svc, ctx)
+	require.Len(t, codes, recoveryCodeCount)
+	for _, code := range codes {
+		require.Len(t, strings.ReplaceAll(code, "-", ""), 16, "80 bit codes")
+	}
+
+	challenge := func() string {
+		result, err := svc.Login(context.Background(), creds, model.ClientInfo{})
+		require.NoError(t, err)
+		require.Nil(t, result.Tokens)
+		require.NotNil(t, result.Challenge)
+		return result.Challenge.ChallengeToken
+	}
+	complete := func(token string, code string) error {
+		_, err := svc.CompleteLogin(model.ChallengeResponse{ChallengeToken: token, Code: code}, model.ClientInfo{})
+		return err
+	}
+
+	token := challenge()
+	require.ErrorIs(t, complete(token, "abcdef"), errs.ErrInvalidTOTPCode)
+	require.ErrorIs(t, complete(token, codes[0]), errs.ErrInvalidChallenge, "a failed challenge can't be retried")
+
+	token = challenge()
+	require.NoError(t, complete(token, codes[0]))
+	require.ErrorIs(t, complete(token, codes[1]), errs.ErrInvalidChallenge, "a completed challenge can't be reused")
+	require.ErrorIs(t, complete(challenge(), codes[0]), errs.ErrInvalidTOTPCode, "recovery codes are single use")
+	require.NoError(t, complete(challenge(), strings.ToUpper(codes[1])), "the code of a reused challenge isn't spent")
+}
+
+func TestWithdrawTOTP(t *testing.T) {
+	svc, repo := newTestService(t, func(cfg *config.Config) {
+		cfg.TOTPWithdrawThreshold = 100
+	})
+	userID, ctx := registerUser(t, svc, repo, model.UserCredentials{Login: "user", Password: "secret"})
+	require.NoError(t, repo.AddOrder(userID, "12345678903", 0))
+	_, err := repo.UpdateAccrual(model.AccrualResp{Order: "12345678903", Status: "PROCESSED", Accrual: 500})
+	require.NoError(t, err)
+	withdraw := func(order string, sum float64, code string) error {
+		return svc.Withdraw(ctx, model.Withdraw{Order: order, Sum: sum, TOTPCode: code})
+	}
+
+	require.NoError(t, withdraw("2377225624", 120, ""), "no code is needed without two-factor authentication")
+	totpSecret, codes := enableTOTP(t, svc, ctx)
+	code, err := totp.Code(totpSecret, time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, withdraw("79927398713", 100, ""), "withdrawals up to the threshold need no code")
+	require.ErrorIs(t, withdraw("49927398716", 150, ""), errs.ErrTOTPRequired)
+	require.ErrorIs(t, withdraw("49927398716", 150, "abcdef"), errs.ErrInvalidTOTPCode)
+	require.ErrorIs(t, withdraw("49927398716", 150, codes[0]), errs.ErrInvalidTOTPCode, "recovery codes aren't accepted")
+
+	require.ErrorIs(t, withdraw("49927398716", 1000, code), errs.ErrBalanceNotEnoughPoints)
+	require.NoError(t, withdraw("49927398716", 150, code), "the code isn't spent on a withdrawal bound to fail")
+	require.ErrorIs(t, withdraw("4561261212345467", 120.5, code), errs.ErrInvalidTOTPCode, "replayed code")
+
+	balance, err := repo.GetBalance(userID)
+	require.NoError(t, err)
+	require.InDelta(t, 130.0, balance.Current, 1e-9)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/errs"
+	"github.com/kuznet1/gophermart/internal/model"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultLoginPattern = `^[[:graph:]]{3,64}$`
+	// bcrypt ignores everything past 72 bytes of the password
+	maxPasswordBytes = 72
+)
+
+type CredentialsValidator struct {
+	loginPattern      *regexp.Regexp
+	minPasswordLength int
+	maxPasswordLength int
+	blocklist         map[string]struct{}
+}
+
+func NewCredentialsValidator(cfg config.Config) (*CredentialsValidator, error) {
+	pattern := cfg.LoginPattern
+	if pattern == "" {
+		pattern = defaultLoginPattern
+	}
+	loginPattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid login pattern: %w", err)
+	}
+
+	maxPasswordLength := cfg.PasswordMaxLength
+	if maxPasswordLength <= 0 || maxPasswordLength > maxPasswordBytes {
+		maxPasswordLength = maxPasswordBytes
+	}
+	if cfg.PasswordMinLength > maxPasswordLength {
+		return nil, fmt.Errorf("min password length %d exceeds max password length %d", cfg.PasswordMinLength, maxPasswordLength)
+	}
+
+	blocklist, err := loadBlocklist(cfg.PasswordBlocklistPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialsValidator{
+		loginPattern:      loginPattern,
+		minPasswordLength: cfg.PasswordMinLength,
+		maxPasswordLength: maxPasswordLength,
+		blocklist:         blocklist,
+	}, nil
+}
+
+// loadBlocklist reads common or breached passwords, one per line.
+func loadBlocklist(path string) (map[string]struct{}, error) {
+	blocklist := make(map[string]struct{})
+	if path == "" {
+		return blocklist, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open password blocklist: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			blocklist[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read password blocklist: %w", err)
+	}
+	return blocklist, nil
+}
+
+func (v *CredentialsValidator) Validate(creds model.UserCredentials) error {
+	verr := errs.NewValidationError()
+	switch {
+	case creds.Login == "":
+		verr.Add("login", "is required")
+	case !v.loginPattern.MatchString(creds.Login), strings.HasPrefix(creds.Login, model.AnonymizedLoginPrefix):
+		verr.Add("login", "has invalid format")
+	}
+	v.validatePassword(verr, "password", creds.Login, creds.Password)
+	return verr.Err()
+}
+
+// ValidatePassword checks a new password of the user with the given login.
+func (v *CredentialsValidator) ValidatePassword(field string, login string, password string) error {
+	verr := errs.NewValidationError()
+	v.validatePassword(verr, field, login, password)
+	return verr.Err()
+}
+
+func (v *CredentialsValidator) validatePassword(verr *errs.ValidationError, field string, login string, password string) {
+	switch {
+	case password == "":
+		verr.Add(field, "is required")
+	case utf8.RuneCountInString(password) < v.minPasswordLength:
+		verr.Add(field, fmt.Sprintf("must be at least %d characters long", v.minPasswordLength))
+	case v.PasswordTooLong(password):
+		verr.Add(field, fmt.Sprintf("must be at most %d bytes long", v.maxPasswordLength))
+	case login != "" && strings.EqualFold(password, login):
+		verr.Add(field, "must differ from login")
+	default:
+		if _, ok := v.blocklist[strings.ToLower(password)]; ok {
+			verr.Add(field, "is too common")
+		}
+	}
+}
+
+func (v *CredentialsValidator) PasswordTooLong(password string) bool {
+	return len(password) > v.maxPasswordLength
+}
@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, for HMAC-SHA1.
+	key := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, code := range tests {
+		require.Equal(t, code, generate(key, counter(time.Unix(unix, 0)), 8), unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+
+	c, ok := Validate(secret, code, now)
+	require.True(t, ok)
+	require.Equal(t, counter(now), c)
+
+	_, ok = Validate(secret, code, now.Add(Period*time.Second))
+	require.True(t, ok, "previous step is accepted")
+
+	_, ok = Validate(secret, code, now.Add(3*Period*time.Second))
+	require.False(t, ok, "old code is rejected")
+
+	_, ok = Validate(secret, "12345", now)
+	require.False(t, ok)
+}
+
+func TestSecretEncoding(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	code, err := Code(strings.ToLower(secret), time.Unix(59, 0))
+	require.NoError(t, err)
+	require.Equal(t, "287082", code)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Gophermart", "user@example.com", "JBSWY3DPEHPK3PXP")
+	require.Equal(t, "otpauth://totp/Gophermart:user@example.com?algorithm=SHA1&digits=6&issuer=Gophermart&period=30&secret=JBSWY3DPEHPK3PXP", uri)
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrInternalAddress = errors.New("webhook address belongs to the internal network")
+
+// newClient returns the HTTP client deliveries are made with. Hosts that aren't allowed explicitly
+// may only be reached at public addresses, and redirects are never followed, so that a webhook
+// can't be used to make requests to the internal network.
+func newClient(allowedHosts map[string]bool) *http.Client {
+	public := &net.Dialer{Timeout: deliveryTimeout, Control: publicOnly}
+	internal := &net.Dialer{Timeout: deliveryTimeout}
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			// no proxy: the address checked must be the address of the receiver
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(address)
+				if err == nil && allowedHosts[strings.ToLower(host)] {
+					return internal.DialContext(ctx, network, address)
+				}
+				return public.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: deliveryTimeout,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        100,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicOnly is a dialer control function refusing connections to internal addresses. It runs
+// once the host name is resolved, so names resolving to internal addresses are refused as well.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isInternal(ip) {
+		return fmt.Errorf("%w: %s", ErrInternalAddress, host)
+	}
+	return nil
+}
+
+// nonPublicPrefixes are the special-purpose ranges (RFC 6890) that aren't reachable on the public
+// internet, but may be on the internal network, e.g. carrier-grade NAT addresses cloud providers
+// put metadata and other internal services on.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/127"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// nat64Prefix is the well-known NAT64 prefix, which embeds an IPv4 address in the last 32 bits.
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+func isInternal(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	// IPv4-mapped and NAT64 addresses reach the IPv4 address they embed
+	addr = addr.Unmap()
+	if nat64Prefix.Contains(addr) {
+		embedded := addr.As16()
+		addr = netip.AddrFrom4([4]byte(embedded[12:]))
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidURL reports whether deliveries may be made to the URL: it must be an absolute http or https
+// URL and, unless its host is allowed explicitly, must not name localhost or an internal address.
+// Host names are checked again on every delivery, when they are resolved.
+func (d *Dispatcher) ValidURL(u *url.URL) bool {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if d.allowedHosts[host] {
+		return true
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !isInternal(ip)
+}
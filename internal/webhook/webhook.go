@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/logger"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventOrderProcessed   = "order.processed"
+	EventBalanceWithdrawn = "balance.withdrawn"
+)
+
+var Events = []string{EventOrderProcessed, EventBalanceWithdrawn}
+
+const (
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	SignatureHeader = "X-Gophermart-Signature"
+)
+
+const (
+	pollInterval    = 5 * time.Second
+	claimBatchSize  = 50
+	claimLease      = time.Minute
+	deliveryTimeout = 10 * time.Second
+	maxAttempts     = 8
+	baseBackoff     = 30 * time.Second
+	maxBackoff      = time.Hour
+)
+
+type envelope struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Dispatcher delivers webhook events stored in the outbox table and retries
+// failed deliveries with exponential backoff.
+type Dispatcher struct {
+	signal chan struct{}
+	done   chan struct{}
+	repo   repository.WebhookStorage
+	client *http.Client
+	// allowedHosts may resolve to internal addresses
+	allowedHosts map[string]bool
+}
+
+func NewDispatcher(cfg config.Config, repo repository.WebhookStorage) *Dispatcher {
+	allowedHosts := make(map[string]bool)
+	for _, host := range strings.Split(cfg.WebhookAllowedHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			allowedHosts[strings.ToLower(host)] = true
+		}
+	}
+	return &Dispatcher{
+		signal:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		repo:         repo,
+		client:       newClient(allowedHosts),
+		allowedHosts: allowedHosts,
+	}
+}
+
+func (d *Dispatcher) Start() {
+	go d.run()
+	d.Signal()
+}
+
+func (d *Dispatcher) Stop() {
+	close(d.done)
+}
+
+func (d *Dispatcher) Signal() {
+	select {
+	case d.signal <- struct{}{}:
+	default:
+	}
+}
+
+// NewEvent builds the event with the payload delivered to webhooks, for storages to enqueue in
+// the same transaction as the change it reports. Signal must be called once it's committed.
+func NewEvent(event string, data any) (model.WebhookEvent, error) {
+	payload, err := json.Marshal(envelope{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return model.WebhookEvent{}, fmt.Errorf("failed to marshal webhook event %s: %w", event, err)
+	}
+	return model.WebhookEvent{Event: event, Payload: payload}, nil
+}
+
+// Notify enqueues the event for every webhook of the user subscribed to it.
+func (d *Dispatcher) Notify(userID int, event string, data any) {
+	webhookEvent, err := NewEvent(event, data)
+	if err != nil {
+		logger.Log.Error("failed to build webhook event", zap.String("event", event), zap.Error(err))
+		return
+	}
+
+	enqueued, err := d.repo.EnqueueWebhookEvent(userID, event, webhookEvent.Payload)
+	if err != nil {
+		logger.Log.Error("failed to enqueue webhook event", zap.String("event", event), zap.Error(err))
+		return
+	}
+	if enqueued > 0 {
+		d.Signal()
+	}
+}
+
+func (d *Dispatcher) run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-d.signal:
+		case <-ticker.C:
+		}
+		d.deliverDue()
+	}
+}
+
+func (d *Dispatcher) deliverDue() {
+	for {
+		deliveries, err := d.repo.ClaimWebhookDeliveries(claimBatchSize, claimLease)
+		if err != nil {
+			logger.Log.Error("failed to claim webhook deliveries", zap.Error(err))
+			return
+		}
+
+		for _, delivery := range deliveries {
+			ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+			code, err := Deliver(ctx, d.client, delivery)
+			cancel()
+			d.record(delivery, code, err)
+		}
+
+		if len(deliveries) < claimBatchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) record(delivery model.WebhookDelivery, code int, deliveryErr error) {
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.LastError = ""
+	var retryIn time.Duration
+	switch {
+	case deliveryErr == nil:
+		delivery.Status = "DELIVERED"
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = "FAILED"
+		delivery.LastError = deliveryErr.Error()
+	default:
+		delivery.Status = "PENDING"
+		delivery.LastError = deliveryErr.Error()
+		retryIn = backoff(delivery.Attempts)
+	}
+
+	if err := d.repo.RecordWebhookAttempt(delivery, retryIn); err != nil {
+		logger.Log.Error("failed to record webhook attempt", zap.Int64("delivery", delivery.ID), zap.Error(err))
+	}
+}
+
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff << (attempts - 1)
+	if delay <= 0 || delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// Deliver posts the signed delivery payload to its webhook URL. Any non-2xx response is an error.
+func Deliver(ctx context.Context, client *http.Client, delivery model.WebhookDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the value of the signature header: HMAC-SHA256 of "<timestamp>.<body>"
+// keyed with the webhook secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
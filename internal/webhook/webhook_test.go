@@ -0,0 +1,185 @@
+package webhook
+
+import (
+	"context"
+	"github.com/kuznet1/gophermart/internal/config"
+	"github.com/kuznet1/gophermart/internal/model"
+	"github.com/kuznet1/gophermart/internal/repository"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeliver(t *testing.T) {
+	delivery := model.WebhookDelivery{
+		ID:      42,
+		Event:   EventOrderProcessed,
+		Payload: []byte(`{"event":"order.processed","data":{"number":"12345678903"}}`),
+		Secret:  "whsec_test",
+	}
+
+	var received *http.Request
+	var body []byte
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	delivery.URL = receiver.URL
+
+	t.Run("signed delivery", func(t *testing.T) {
+		code, err := Deliver(context.Background(), receiver.Client(), delivery)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, code)
+
+		require.JSONEq(t, string(delivery.Payload), string(body))
+		require.Equal(t, EventOrderProcessed, received.Header.Get(EventHeader))
+		require.Equal(t, "42", received.Header.Get(DeliveryHeader))
+
+		timestamp, err := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+		require.NoError(t, err)
+		require.Equal(t, Sign(delivery.Secret, timestamp, body), received.Header.Get(SignatureHeader))
+		require.NotEqual(t, Sign("other", timestamp, body), received.Header.Get(SignatureHeader))
+	})
+
+	t.Run("failed delivery", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		code, err := Deliver(context.Background(), receiver.Client(), delivery)
+		require.Error(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, baseBackoff, backoff(1))
+	require.Equal(t, 2*baseBackoff, backoff(2))
+	require.Equal(t, maxBackoff, backoff(maxAttempts))
+	require.Equal(t, maxBackoff, backoff(100))
+	require.LessOrEqual(t, backoff(7), time.Hour)
+}
+
+func TestValidURL(t *testing.T) {
+	d := NewDispatcher(config.Config{WebhookAllowedHosts: "hooks.internal, 10.1.2.3"}, repository.NewMemRepo())
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://93.184.216.34:8080/hook", true},
+		{"http://hooks.internal/hook", true},
+		{"http://10.1.2.3/hook", true},
+		{"ftp://example.com/hook", false},
+		{"/hook", false},
+		{"http://localhost:8080/hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://10.0.0.1/hook", false},
+		{"http://192.168.1.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://224.0.0.1/hook", false},
+		{"http://[fe80::1]/hook", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://100.127.255.254/hook", false},
+		{"http://100.128.0.1/hook", true},
+		{"http://0.1.2.3/hook", false},
+		{"http://192.0.0.170/hook", false},
+		{"http://198.18.0.1/hook", false},
+		{"http://198.19.255.255/hook", false},
+		{"http://240.0.0.1/hook", false},
+		{"http://[fd00:ec2::254]/hook", false},
+		{"http://[::ffff:127.0.0.1]/hook", false},
+		{"http://[::ffff:169.254.169.254]/hook", false},
+		{"http://[::ffff:93.184.216.34]/hook", true},
+		{"http://[64:ff9b::a9fe:a9fe]/hook", false},
+		{"http://[64:ff9b::5db8:d822]/hook", true},
+		{"http://[64:ff9b:1::a00:1]/hook", false},
+		{"http://[2606:2800:220:1::1]/hook", true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		require.NoError(t, err)
+		require.Equal(t, tt.valid, d.ValidURL(u), tt.url)
+	}
+}
+
+func TestClient(t *testing.T) {
+	delivery := model.WebhookDelivery{ID: 1, Event: EventOrderProcessed, Payload: []byte(`{}`), Secret: "whsec_test"}
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	t.Run("internal address", func(t *testing.T) {
+		d := NewDispatcher(config.Config{}, repository.NewMemRepo())
+		delivery.URL = target.URL
+		_, err := Deliver(context.Background(), d.client, delivery)
+		require.ErrorIs(t, err, ErrInternalAddress)
+	})
+
+	t.Run("allowed host", func(t *testing.T) {
+		d := NewDispatcher(config.Config{WebhookAllowedHosts: "127.0.0.1"}, repository.NewMemRepo())
+		delivery.URL = target.URL
+		code, err := Deliver(context.Background(), d.client, delivery)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, code)
+	})
+
+	t.Run("redirect is not followed", func(t *testing.T) {
+		d := NewDispatcher(config.Config{WebhookAllowedHosts: "127.0.0.1"}, repository.NewMemRepo())
+		delivery.URL = redirect.URL
+		code, err := Deliver(context.Background(), d.client, delivery)
+		require.Error(t, err)
+		require.Equal(t, http.StatusTemporaryRedirect, code)
+	})
+}
+
+func TestDispatcher(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	repo := repository.NewMemRepo()
+	d := NewDispatcher(config.Config{WebhookAllowedHosts: "127.0.0.1"}, repo)
+	hook, err := repo.CreateWebhook(1, receiver.URL, "whsec_test", []string{EventBalanceWithdrawn})
+	require.NoError(t, err)
+	d.Start()
+	defer d.Stop()
+
+	d.Notify(1, EventOrderProcessed, model.Order{Order: "12345678903"})
+	d.Notify(1, EventBalanceWithdrawn, model.Withdrawal{Order: "2377225624", Sum: 100})
+
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-received:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook is not delivered")
+	}
+	require.Equal(t, EventBalanceWithdrawn, r.Header.Get(EventHeader), "only subscribed events are delivered")
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, Sign("whsec_test", timestamp, body), r.Header.Get(SignatureHeader))
+	require.Contains(t, string(body), `"event":"balance.withdrawn"`)
+
+	require.Eventually(t, func() bool {
+		deliveries, err := repo.GetWebhookDeliveries(1, hook.ID)
+		return err == nil && len(deliveries) == 1 && deliveries[0].Status == "DELIVERED" && deliveries[0].Attempts == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
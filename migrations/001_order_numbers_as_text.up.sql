@@ -0,0 +1,7 @@
+ALTER TABLE orders
+    ALTER COLUMN order_id TYPE TEXT USING order_id::TEXT,
+    ADD CONSTRAINT orders_order_id_digits CHECK (order_id ~ '^[0-9]+$');
+
+ALTER TABLE withdrawals
+    ALTER COLUMN order_id TYPE TEXT USING order_id::TEXT,
+    ADD CONSTRAINT withdrawals_order_id_digits CHECK (order_id ~ '^[0-9]+$');
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS order_audit
+(
+    id          SERIAL PRIMARY KEY,
+    order_id    TEXT      NOT NULL,
+    user_id     INTEGER   NOT NULL,
+    action      TEXT      NOT NULL,
+    status      status    NOT NULL,
+    uploaded_at TIMESTAMP NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT now(),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS order_audit_order_id_idx ON order_audit (order_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_events
+(
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INTEGER   NOT NULL,
+    order_id   TEXT      NOT NULL,
+    status     status    NOT NULL,
+    accrual    numeric(8, 2)      DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS order_events_user_id_idx ON order_events (user_id, id);
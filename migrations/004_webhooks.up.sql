@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER   NOT NULL,
+    url        TEXT      NOT NULL,
+    secret     TEXT      NOT NULL,
+    events     TEXT[]    NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              BIGSERIAL PRIMARY KEY,
+    webhook_id      INTEGER   NOT NULL,
+    event           TEXT      NOT NULL,
+    payload         JSONB     NOT NULL,
+    status          TEXT      NOT NULL DEFAULT 'PENDING',
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+    response_code   INTEGER,
+    last_error      TEXT,
+    created_at      TIMESTAMP NOT NULL DEFAULT now(),
+    delivered_at    TIMESTAMP,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks (id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
@@ -0,0 +1,26 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS data_version BIGINT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION bump_user_data_version() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE users SET data_version = data_version + 1 WHERE id = OLD.user_id;
+        RETURN OLD;
+    END IF;
+    UPDATE users SET data_version = data_version + 1 WHERE id = NEW.user_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_bump_user_data_version
+    AFTER INSERT OR UPDATE OR DELETE
+    ON orders
+    FOR EACH ROW
+EXECUTE FUNCTION bump_user_data_version();
+
+CREATE TRIGGER withdrawals_bump_user_data_version
+    AFTER INSERT OR UPDATE OR DELETE
+    ON withdrawals
+    FOR EACH ROW
+EXECUTE FUNCTION bump_user_data_version();
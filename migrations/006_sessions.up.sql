@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS sessions
+(
+    id         TEXT PRIMARY KEY,
+    user_id    INTEGER   NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    token_hash TEXT PRIMARY KEY,
+    session_id TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    used_at    TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES sessions (id)
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_session_id_idx ON refresh_tokens (session_id);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_throttle
+(
+    key             TEXT PRIMARY KEY,
+    failures        INTEGER   NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT now(),
+    locked_until    TIMESTAMP
+);
@@ -0,0 +1,13 @@
+ALTER TABLE users
+    ADD COLUMN role       TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin')),
+    ADD COLUMN blocked_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS admin_audit
+(
+    id         BIGSERIAL PRIMARY KEY,
+    admin_id   INTEGER   NOT NULL REFERENCES users (id),
+    action     TEXT      NOT NULL,
+    target     TEXT      NOT NULL DEFAULT '',
+    details    JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
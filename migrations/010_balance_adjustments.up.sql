@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS balance_adjustments
+(
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER       NOT NULL REFERENCES users (id),
+    amount      numeric(8, 2) NOT NULL CHECK (amount <> 0),
+    reason      TEXT          NOT NULL CHECK (reason IN ('compensation', 'goodwill', 'fraud', 'correction')),
+    comment     TEXT          NOT NULL,
+    status      TEXT          NOT NULL CHECK (status IN ('PENDING', 'APPLIED', 'REJECTED')),
+    created_by  INTEGER       NOT NULL REFERENCES users (id),
+    created_at  TIMESTAMP     NOT NULL DEFAULT now(),
+    reviewed_by INTEGER REFERENCES users (id),
+    reviewed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_id_idx ON balance_adjustments (user_id);
+
+CREATE TRIGGER balance_adjustments_bump_user_data_version
+    AFTER INSERT OR UPDATE OR DELETE
+    ON balance_adjustments
+    FOR EACH ROW
+EXECUTE FUNCTION bump_user_data_version();
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS partners
+(
+    id         SERIAL PRIMARY KEY,
+    name       TEXT      NOT NULL,
+    key_id     TEXT      NOT NULL UNIQUE,
+    secret     TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS partner_customers
+(
+    partner_id  INTEGER   NOT NULL REFERENCES partners (id),
+    external_id TEXT      NOT NULL,
+    user_id     INTEGER   NOT NULL REFERENCES users (id),
+    created_at  TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (partner_id, external_id)
+);
+
+ALTER TABLE orders
+    ADD COLUMN partner_id INTEGER REFERENCES partners (id);
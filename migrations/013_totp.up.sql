@@ -0,0 +1,12 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret       TEXT,
+    ADD COLUMN totp_enabled_at   TIMESTAMP,
+    ADD COLUMN totp_last_counter BIGINT;
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id   INTEGER NOT NULL REFERENCES users (id),
+    code_hash TEXT    NOT NULL,
+    used_at   TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
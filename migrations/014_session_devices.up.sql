@@ -0,0 +1,4 @@
+ALTER TABLE sessions
+    ADD COLUMN user_agent   TEXT      NOT NULL DEFAULT '',
+    ADD COLUMN ip           TEXT      NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMP NOT NULL DEFAULT now();
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS partner_nonces
+(
+    partner_id INTEGER   NOT NULL REFERENCES partners (id),
+    nonce      TEXT      NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (partner_id, nonce)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS used_challenges
+(
+    challenge_id TEXT PRIMARY KEY,
+    expires_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS used_challenges_expires_at_idx ON used_challenges (expires_at);